@@ -0,0 +1,332 @@
+// Package apikeys keeps the keys clients of the API authenticate with.
+// Only a SHA-256 hash of each key is stored; the key itself is shown
+// once, when it is created.
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shallowseek/models"
+)
+
+// Scopes a key may carry. Admin allows everything.
+const (
+	ScopeSearch = "search"
+	ScopeUpload = "upload"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin"
+)
+
+var scopes = map[string]bool{
+	ScopeSearch: true,
+	ScopeUpload: true,
+	ScopeDelete: true,
+	ScopeAdmin:  true,
+}
+
+// BootstrapID is the ID of the key set by configuration rather than
+// created through the API.
+const BootstrapID = "bootstrap"
+
+// keyPrefix starts every generated key, so leaked keys are easy to
+// recognize.
+const keyPrefix = "ssk_"
+
+var (
+	ErrNotFound      = errors.New("API key not found")
+	ErrInvalid       = errors.New("invalid API key")
+	ErrUnauthorized  = errors.New("unknown or revoked API key")
+	ErrQuotaExceeded = errors.New("API key rate limit exceeded")
+)
+
+// Key is an API key as stored. Prefix is the start of the key, to tell
+// keys apart without revealing them. RateLimit is the most requests per
+// minute the key may make, 0 for no limit.
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"hash,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	RateLimit int        `json:"rate_limit,omitempty"`
+	Created   time.Time  `json:"created"`
+	Revoked   *time.Time `json:"revoked,omitempty"`
+}
+
+// Allows reports whether the key carries a scope.
+func (k *Key) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// public returns the key without its hash.
+func (k *Key) public() Key {
+	key := *k
+	key.Hash = ""
+	return key
+}
+
+// Status is a key with its use since the server started.
+type Status struct {
+	Key
+	Requests int64      `json:"requests"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+type usage struct {
+	requests int64
+	lastUsed time.Time
+	// window is the minute the rate limit is counted in.
+	window      time.Time
+	windowCount int
+}
+
+// Manager keeps the keys in a JSON file. Their use is counted in memory
+// only.
+type Manager struct {
+	mu        sync.Mutex
+	path      string
+	keys      map[string]*Key
+	byHash    map[string]*Key
+	usage     map[string]*usage
+	bootstrap *Key
+}
+
+// NewManager loads the keys saved at path. A non-empty bootstrapKey is
+// accepted too, with every scope, so the first keys can be created.
+func NewManager(path, bootstrapKey string) *Manager {
+	m := &Manager{
+		path:   path,
+		keys:   make(map[string]*Key),
+		byHash: make(map[string]*Key),
+		usage:  make(map[string]*usage),
+	}
+
+	if data, err := os.ReadFile(path); err == nil {
+		var keys []*Key
+		if err := json.Unmarshal(data, &keys); err != nil {
+			log.Printf("[APIKeys] Error parsing %s: %v", path, err)
+		}
+		for _, key := range keys {
+			m.keys[key.ID] = key
+			m.byHash[key.Hash] = key
+		}
+		log.Printf("[APIKeys] Loaded %d keys", len(keys))
+	} else if !os.IsNotExist(err) {
+		log.Printf("[APIKeys] Error reading %s: %v", path, err)
+	}
+
+	if bootstrapKey != "" {
+		m.bootstrap = &Key{
+			ID:      BootstrapID,
+			Name:    "Bootstrap key",
+			Hash:    hash(bootstrapKey),
+			Scopes:  []string{ScopeAdmin},
+			Created: time.Now(),
+		}
+	}
+	return m
+}
+
+// Create adds a key and returns it with the key itself, which is not
+// kept and can't be shown again.
+func (m *Manager) Create(key Key) (Key, string, error) {
+	if err := validate(&key); err != nil {
+		return Key{}, "", err
+	}
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return Key{}, "", err
+	}
+	secret := keyPrefix + hex.EncodeToString(random)
+
+	key.ID = models.GenerateID()
+	key.Prefix = prefixOf(secret)
+	key.Hash = hash(secret)
+	key.Created = time.Now()
+	key.Revoked = nil
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.ID] = &key
+	m.byHash[key.Hash] = &key
+	if err := m.save(); err != nil {
+		delete(m.keys, key.ID)
+		delete(m.byHash, key.Hash)
+		return Key{}, "", err
+	}
+	return key.public(), secret, nil
+}
+
+// Revoke stops a key from being accepted. It stays listed, so the use
+// it made can still be attributed.
+func (m *Manager) Revoke(id string) (Key, error) {
+	if id == BootstrapID {
+		return Key{}, fmt.Errorf("%w: the bootstrap key is set by configuration", ErrInvalid)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	if key.Revoked != nil {
+		return key.public(), nil
+	}
+	now := time.Now()
+	key.Revoked = &now
+	if err := m.save(); err != nil {
+		key.Revoked = nil
+		return Key{}, err
+	}
+	return key.public(), nil
+}
+
+func (m *Manager) Get(id string) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[id]
+	if id == BootstrapID && m.bootstrap != nil {
+		key, ok = m.bootstrap, true
+	}
+	if !ok {
+		return Status{}, ErrNotFound
+	}
+	return m.status(key), nil
+}
+
+// List returns the keys, oldest first, the bootstrap key first of all.
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Status, 0, len(m.keys)+1)
+	for _, key := range m.keys {
+		list = append(list, m.status(key))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	if m.bootstrap != nil {
+		list = append([]Status{m.status(m.bootstrap)}, list...)
+	}
+	return list
+}
+
+// status is called with m.mu held.
+func (m *Manager) status(key *Key) Status {
+	status := Status{Key: key.public()}
+	if u, ok := m.usage[key.ID]; ok {
+		status.Requests = u.requests
+		lastUsed := u.lastUsed
+		status.LastUsed = &lastUsed
+	}
+	return status
+}
+
+// Authenticate returns the key a client presented, counting the request
+// against it. It returns ErrUnauthorized for keys that are unknown or
+// revoked and ErrQuotaExceeded once the key has made its RateLimit
+// requests this minute.
+func (m *Manager) Authenticate(secret string) (Key, error) {
+	h := hash(secret)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.byHash[h]
+	if m.bootstrap != nil && m.bootstrap.Hash == h {
+		key, ok = m.bootstrap, true
+	}
+	if !ok || key.Revoked != nil {
+		return Key{}, ErrUnauthorized
+	}
+
+	now := time.Now()
+	u, ok := m.usage[key.ID]
+	if !ok {
+		u = &usage{}
+		m.usage[key.ID] = u
+	}
+	if window := now.Truncate(time.Minute); !u.window.Equal(window) {
+		u.window = window
+		u.windowCount = 0
+	}
+	if key.RateLimit > 0 && u.windowCount >= key.RateLimit {
+		return key.public(), ErrQuotaExceeded
+	}
+	u.windowCount++
+	u.requests++
+	u.lastUsed = now
+	return key.public(), nil
+}
+
+// save writes the keys through a temporary file. Called with m.mu held.
+func (m *Manager) save() error {
+	keys := make([]*Key, 0, len(m.keys))
+	for _, key := range m.keys {
+		keys = append(keys, key)
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to save API keys: %v", err)
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save API keys: %v", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to save API keys: %v", err)
+	}
+	return nil
+}
+
+// ValidScope reports whether scope is one a key may carry.
+func ValidScope(scope string) bool {
+	return scopes[scope]
+}
+
+func validate(key *Key) error {
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if len(key.Scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalid)
+	}
+	for _, s := range key.Scopes {
+		if !scopes[s] {
+			return fmt.Errorf("%w: unknown scope %q", ErrInvalid, s)
+		}
+	}
+	if key.RateLimit < 0 {
+		return fmt.Errorf("%w: rate_limit must not be negative", ErrInvalid)
+	}
+	return nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func prefixOf(secret string) string {
+	return secret[:len(keyPrefix)+8]
+}
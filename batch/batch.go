@@ -3,153 +3,588 @@ package batch
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/shallowseek/cache"
+	"github.com/shallowseek/config"
 	"github.com/shallowseek/elasticsearch"
 	"github.com/shallowseek/metrics"
 	"github.com/shallowseek/models"
 )
 
 const (
-	batchSize = 100
-	timeout   = 30 * time.Second
-	flushInterval = 5 * time.Second
+	// Retryable failures are retried maxAttempts times in all, waiting
+	// an exponentially growing, jittered delay between attempts.
+	maxAttempts    = 8
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 5 * time.Minute
+
+	// refreshDelay is how long documents sent without waiting for a
+	// refresh may take to become searchable: the default refresh
+	// interval, with room to spare.
+	refreshDelay = 2 * time.Second
 )
 
+// ErrQueueFull is returned by AddDocuments while the queue holds as many
+// documents, or as many bytes, as it is allowed to.
+var ErrQueueFull = errors.New("indexing queue is full")
+
+// queued is a document in the batch queue with the state of its retries
+// and the log segment that keeps it on disk.
+type queued struct {
+	doc       models.Document
+	size      int
+	segment   uint64
+	attempts  int
+	notBefore time.Time
+}
+
+// itemFailure is a document of a bulk request that Elasticsearch did
+// not index. Status is 0 when the request itself failed in transport.
+type itemFailure struct {
+	index  int
+	status int
+	reason string
+}
+
+// Listener is told the outcome of the documents sent to Elasticsearch.
+// Once a document is indexed err is nil and result is "created" or
+// "updated"; otherwise err is the failure that moved it to the dead
+// letter store. Documents waiting for a retry are not reported.
+type Listener func(doc models.Document, result string, err error)
+
+// BatchProcessor queues documents and indexes them with a pool of
+// workers, each sending bulk requests of up to batchSize documents or
+// batchBytes bytes. A worker sends as soon as a full batch is waiting,
+// and every flushInterval sends whatever is left.
 type BatchProcessor struct {
-	documents []models.Document
-	mu        sync.Mutex
-	stopChan  chan struct{}
+	queue         []queued
+	queuedBytes   int
+	inFlight      int
+	inFlightBytes int
+	wal           *wal
+	deadLetters   *deadLetterStore
+	listeners     []Listener
+	mu            sync.Mutex
+
+	// imports counts the running bulk imports; importOn is set while
+	// the index has the import settings.
+	importMu      sync.Mutex
+	imports       int
+	importStarted time.Time
+	importOn      atomic.Bool
+
+	workers       int
+	batchSize     int
+	batchBytes    int
+	flushInterval time.Duration
+	timeout       time.Duration
+	maxQueue      int
+	maxQueueBytes int
+
+	wake     chan struct{}
+	stopChan chan struct{}
+	wg       sync.WaitGroup
 }
 
+// NewBatchProcessor opens the write-ahead log and queues the documents
+// a previous run accepted but never got indexed. If the log can't be
+// opened, AddDocument refuses documents rather than accept them without
+// a way to recover them.
 func NewBatchProcessor() *BatchProcessor {
 	bp := &BatchProcessor{
-		documents: make([]models.Document, 0, batchSize),
-		stopChan:  make(chan struct{}),
+		workers:       config.GetBulkWorkers(),
+		batchSize:     config.GetBulkBatchSize(),
+		batchBytes:    config.GetBulkBatchBytes(),
+		flushInterval: config.GetBulkFlushInterval(),
+		timeout:       config.GetBulkTimeout(),
+		maxQueue:      config.GetBulkQueueSize(),
+		maxQueueBytes: config.GetBulkQueueBytes(),
+		stopChan:      make(chan struct{}),
+	}
+	bp.wake = make(chan struct{}, bp.workers)
+
+	w, records, err := openWAL(config.GetWALDir())
+	if err != nil {
+		log.Printf("[Batch] Error opening write-ahead log: %v", err)
+	} else {
+		bp.wal = w
+		for _, record := range records {
+			bp.queue = append(bp.queue, queued{doc: record.doc, size: record.size, segment: record.segment})
+			bp.queuedBytes += record.size
+		}
+		if len(records) > 0 {
+			log.Printf("[Batch] Replaying %d documents from write-ahead log", len(records))
+		}
+	}
+
+	if bp.deadLetters, err = openDeadLetterStore(config.GetDeadLetterDir()); err != nil {
+		log.Printf("[Batch] Error opening dead letter store: %v", err)
+	}
+	bp.updateQueueMetrics()
+
+	// An import that was running when the server stopped left the index
+	// without periodic refreshes until RestoreIndexSettings runs.
+	if state, _ := readImportState(); state != nil {
+		bp.importOn.Store(true)
 	}
-	
-	go bp.periodicFlush()
-	
+
+	log.Printf("[Batch] Starting %d bulk workers (batch size %d, batch bytes %d, flush interval %v)",
+		bp.workers, bp.batchSize, bp.batchBytes, bp.flushInterval)
+	for i := 0; i < bp.workers; i++ {
+		bp.wg.Add(1)
+		go bp.worker()
+	}
+
 	return bp
 }
 
-func (bp *BatchProcessor) periodicFlush() {
-	ticker := time.NewTicker(flushInterval)
+// worker sends batches whenever a full one is waiting, and on every
+// tick sends everything that is due.
+func (bp *BatchProcessor) worker() {
+	defer bp.wg.Done()
+
+	ticker := time.NewTicker(bp.flushInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-bp.wake:
+			for bp.fullBatchReady() {
+				if !bp.sendBatch() {
+					break
+				}
+			}
 		case <-ticker.C:
-			if err := bp.Flush(); err != nil {
-				log.Printf("[Batch] Error during periodic flush: %v", err)
+			for bp.sendBatch() {
 			}
 		case <-bp.stopChan:
-			if err := bp.Flush(); err != nil {
-				log.Printf("[Batch] Error during final flush: %v", err)
-			}
 			return
 		}
 	}
 }
 
+// AddDocument queues a single document; see AddDocuments.
 func (bp *BatchProcessor) AddDocument(doc models.Document) error {
+	return bp.AddDocuments([]models.Document{doc})
+}
+
+// AddDocuments queues documents for indexing. It returns once they are
+// on disk in the write-ahead log, so acknowledged documents survive a
+// crash. Either all of them are queued or, when the queue has no room
+// for them, none and ErrQueueFull.
+func (bp *BatchProcessor) AddDocuments(docs []models.Document) error {
+	entries := make([]queued, 0, len(docs))
+	var size int
+	for _, doc := range docs {
+		if doc.ID == "" {
+			return fmt.Errorf("document ID cannot be empty")
+		}
+		if doc.Content == "" {
+			return fmt.Errorf("document content cannot be empty")
+		}
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal document %s: %v", doc.ID, err)
+		}
+		entries = append(entries, queued{doc: doc, size: len(data)})
+		size += len(data)
+	}
+
 	bp.mu.Lock()
-	defer bp.mu.Unlock()
 
-	log.Printf("[Batch] Adding document %s to batch (current size: %d)", doc.ID, len(bp.documents))
-	
-	if doc.ID == "" {
-		return fmt.Errorf("document ID cannot be empty")
+	log.Printf("[Batch] Adding %d documents to queue (current size: %d)", len(docs), len(bp.queue))
+
+	if bp.wal == nil {
+		bp.mu.Unlock()
+		return fmt.Errorf("write-ahead log is not available")
 	}
-	if doc.Content == "" {
-		return fmt.Errorf("document content cannot be empty")
+	if bp.full(len(entries), size) {
+		bp.mu.Unlock()
+		metrics.BulkRejected.Inc()
+		return ErrQueueFull
 	}
-	
-	bp.documents = append(bp.documents, doc)
 
-	if len(bp.documents) >= batchSize {
-		log.Printf("[Batch] Batch size reached %d, flushing...", batchSize)
-		return bp.Flush()
+	for i := range entries {
+		data, _ := json.Marshal(entries[i].doc)
+		segment, err := bp.wal.append(data)
+		if err != nil {
+			// Documents logged before the failure are dropped from the
+			// log too, so the call has no effect.
+			for _, e := range entries[:i] {
+				bp.wal.release(e.segment)
+			}
+			bp.mu.Unlock()
+			log.Printf("[Batch] Error writing document %s to write-ahead log: %v", entries[i].doc.ID, err)
+			return err
+		}
+		entries[i].segment = segment
 	}
+	bp.queue = append(bp.queue, entries...)
+	bp.queuedBytes += size
+	bp.updateQueueMetrics()
+	ready := bp.fullBatchReadyLocked()
+	bp.mu.Unlock()
 
+	if ready {
+		select {
+		case bp.wake <- struct{}{}:
+		default:
+		}
+	}
 	return nil
 }
 
+// Full reports whether the queue is at its limit, so a caller can turn
+// work away before doing it.
+func (bp *BatchProcessor) Full() bool {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.full(1, 0)
+}
+
+// RetryAfter is how long a client turned away with ErrQueueFull should
+// wait before trying again.
+func (bp *BatchProcessor) RetryAfter() time.Duration {
+	return bp.flushInterval
+}
+
+// full reports whether n more documents of size bytes would exceed the
+// queue limits. An empty queue takes anything, so a single document
+// larger than the byte limit can still be indexed. Called with bp.mu
+// held.
+func (bp *BatchProcessor) full(n, size int) bool {
+	depth := len(bp.queue) + bp.inFlight
+	if depth == 0 {
+		return false
+	}
+	return depth+n > bp.maxQueue || bp.queuedBytes+bp.inFlightBytes+size > bp.maxQueueBytes
+}
+
+func (bp *BatchProcessor) fullBatchReady() bool {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.fullBatchReadyLocked()
+}
+
+// fullBatchReadyLocked reports whether the documents due now make up a
+// full batch. Called with bp.mu held.
+func (bp *BatchProcessor) fullBatchReadyLocked() bool {
+	now := time.Now()
+	var count, size int
+	for _, q := range bp.queue {
+		if q.notBefore.After(now) {
+			continue
+		}
+		count++
+		size += q.size
+		if count >= bp.batchSize || size >= bp.batchBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// take removes the next batch of due documents from the queue: up to
+// batchSize documents or batchBytes bytes, and always at least one.
+func (bp *BatchProcessor) take() []queued {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	now := time.Now()
+	var batch []queued
+	var size int
+	rest := bp.queue[:0]
+	for _, q := range bp.queue {
+		if q.notBefore.After(now) || len(batch) >= bp.batchSize || (len(batch) > 0 && size+q.size > bp.batchBytes) {
+			rest = append(rest, q)
+			continue
+		}
+		batch = append(batch, q)
+		size += q.size
+	}
+	bp.queue = rest
+	bp.queuedBytes -= size
+	bp.inFlight += len(batch)
+	bp.inFlightBytes += size
+	return batch
+}
+
+// sendBatch indexes the next batch and reports whether there was one.
+func (bp *BatchProcessor) sendBatch() bool {
+	batch := bp.take()
+	if len(batch) == 0 {
+		return false
+	}
+	bp.send(batch)
+	return true
+}
+
+// Flush sends every document that is due, batch by batch, and returns
+// once they are indexed, failed for good or waiting for a retry.
 func (bp *BatchProcessor) Flush() error {
+	for bp.sendBatch() {
+	}
+	return nil
+}
+
+// send indexes a batch. Documents that failed for a transient reason go
+// back on the queue to be retried later, and documents refused for good
+// go to the dead letter store. The others are done, and their place in
+// the write-ahead log is released.
+func (bp *BatchProcessor) send(batch []queued) {
+	docs := make([]models.Document, len(batch))
+	for i, q := range batch {
+		docs[i] = q.doc
+	}
+	refresh := bp.refreshPolicy(docs)
+	results, failures, err := bp.index(docs, refresh)
+	if err != nil {
+		log.Printf("[Batch] Error indexing batch: %v", err)
+		failures = make([]itemFailure, len(batch))
+		for i := range batch {
+			failures[i] = itemFailure{index: i, status: bulkErrorStatus(err), reason: err.Error()}
+		}
+	}
+
+	var retry, done []queued
+	failed := make(map[int]bool, len(failures))
+	for _, f := range failures {
+		failed[f.index] = true
+		if q, ok := bp.fail(batch[f.index], f.status, f.reason); ok {
+			retry = append(retry, q)
+		} else {
+			done = append(done, batch[f.index])
+			bp.notify(batch[f.index].doc, "", errors.New(f.reason))
+		}
+	}
+	for i, q := range batch {
+		if !failed[i] {
+			done = append(done, q)
+			bp.notify(q.doc, results[i], nil)
+		}
+	}
+	if len(failures) < len(batch) {
+		invalidateCache(refresh)
+	}
+
 	bp.mu.Lock()
-	if len(bp.documents) == 0 {
-		bp.mu.Unlock()
-		return nil
+	for _, q := range batch {
+		bp.inFlight--
+		bp.inFlightBytes -= q.size
 	}
+	for _, q := range done {
+		if bp.wal != nil {
+			bp.wal.release(q.segment)
+		}
+	}
+	if len(retry) > 0 {
+		log.Printf("[Batch] Requeueing %d documents", len(retry))
+		for _, q := range retry {
+			bp.queuedBytes += q.size
+		}
+		bp.queue = append(retry, bp.queue...)
+	}
+	bp.updateQueueMetrics()
+	bp.mu.Unlock()
+}
+
+// refreshPolicy picks the refresh parameter for a bulk request: the
+// strictest one configured for the sources of its documents, or false
+// during a bulk import, whose index never refreshes on its own.
+func (bp *BatchProcessor) refreshPolicy(docs []models.Document) string {
+	if bp.importOn.Load() {
+		return "false"
+	}
+
+	strictness := map[string]int{"false": 0, "wait_for": 1, "true": 2}
+	policy := "false"
+	for _, doc := range docs {
+		if p := config.GetRefreshPolicy(doc.Source); strictness[p] > strictness[policy] {
+			policy = p
+		}
+	}
+	return policy
+}
 
-	docs := bp.documents
-	bp.documents = make([]models.Document, 0, batchSize)
+// invalidateCache moves the search cache on to a new generation once
+// documents were indexed. Documents sent without waiting for a refresh
+// only become searchable at the next one, so the generation moves on
+// again after it, dropping whatever was cached in between.
+func invalidateCache(refresh string) {
+	cache.BumpGeneration()
+	if refresh == "false" {
+		time.AfterFunc(refreshDelay, cache.BumpGeneration)
+	}
+}
+
+// updateQueueMetrics is called with bp.mu held.
+func (bp *BatchProcessor) updateQueueMetrics() {
+	metrics.BulkQueueDepth.Set(float64(len(bp.queue) + bp.inFlight))
+	metrics.BulkQueueBytes.Set(float64(bp.queuedBytes + bp.inFlightBytes))
+}
+
+// AddListener registers a function to call with the outcome of every
+// document sent to Elasticsearch.
+func (bp *BatchProcessor) AddListener(l Listener) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	bp.listeners = append(bp.listeners, l)
+}
+
+func (bp *BatchProcessor) notify(doc models.Document, result string, err error) {
+	bp.mu.Lock()
+	listeners := bp.listeners
 	bp.mu.Unlock()
+	for _, l := range listeners {
+		l(doc, result, err)
+	}
+}
+
+// fail records a failed attempt at indexing a document. It returns the
+// document to requeue, or false once it is in the dead letter store.
+func (bp *BatchProcessor) fail(q queued, status int, reason string) (queued, bool) {
+	q.attempts++
+	if retryableStatus(status) && q.attempts < maxAttempts {
+		delay := retryDelay(q.attempts)
+		q.notBefore = time.Now().Add(delay)
+		log.Printf("[Batch] Retrying document %s in %v (attempt %d/%d): %s", q.doc.ID, delay.Round(time.Millisecond), q.attempts, maxAttempts, reason)
+		return q, true
+	}
+
+	dl := DeadLetter{
+		Document: q.doc,
+		Error:    reason,
+		Status:   status,
+		Attempts: q.attempts,
+		FailedAt: time.Now(),
+	}
+	if bp.deadLetters == nil {
+		log.Printf("[Batch] No dead letter store for document %s, keeping it queued", q.doc.ID)
+		q.notBefore = time.Now().Add(retryMaxDelay)
+		return q, true
+	}
+	if err := bp.deadLetters.put(dl); err != nil {
+		log.Printf("[Batch] Error storing dead letter %s, keeping it queued: %v", q.doc.ID, err)
+		q.notBefore = time.Now().Add(retryMaxDelay)
+		return q, true
+	}
+	log.Printf("[Batch] Document %s moved to dead letter store after %d attempts: %s", q.doc.ID, q.attempts, reason)
+	return queued{}, false
+}
+
+// retryableStatus reports whether a failure is worth retrying: the
+// request never got an answer, Elasticsearch asked to back off, or it
+// failed on its side.
+func retryableStatus(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryDelay doubles with every attempt up to retryMaxDelay, and picks
+// a random point in the upper half of that so documents that failed
+// together don't all come back at once.
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 20 {
+		if d := retryBaseDelay << (attempt - 1); d < retryMaxDelay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// bulkError is a bulk request Elasticsearch answered with an error
+// status as a whole.
+type bulkError struct {
+	status int
+	body   string
+}
+
+func (e *bulkError) Error() string {
+	return fmt.Sprintf("bulk indexing failed: %s", e.body)
+}
+
+func bulkErrorStatus(err error) int {
+	if be, ok := err.(*bulkError); ok {
+		return be.status
+	}
+	return 0
+}
 
-	log.Printf("[Batch] Flushing batch of %d documents", len(docs))
+// index sends one bulk request and returns, for each document, whether
+// Elasticsearch created or updated it, and the documents it did not
+// index. An error means the request failed as a whole.
+func (bp *BatchProcessor) index(docs []models.Document, refresh string) ([]string, []itemFailure, error) {
+	log.Printf("[Batch] Flushing batch of %d documents (refresh=%s)", len(docs), refresh)
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if elasticsearch.Client == nil {
+		return nil, nil, fmt.Errorf("elasticsearch client is not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), bp.timeout)
 	defer cancel()
 
 	var buf strings.Builder
 	for _, doc := range docs {
 		buf.WriteString(`{"index":{"_index":"documents","_id":"` + doc.ID + `"}}` + "\n")
-		
+
 		docJSON, err := json.Marshal(doc)
 		if err != nil {
 			log.Printf("[Batch] Error marshaling document %s: %v", doc.ID, err)
-			return fmt.Errorf("failed to marshal document %s: %v", doc.ID, err)
+			return nil, nil, fmt.Errorf("failed to marshal document %s: %v", doc.ID, err)
 		}
 		buf.WriteString(string(docJSON) + "\n")
 	}
+	metrics.BulkBatchDocuments.Observe(float64(len(docs)))
+	metrics.BulkBatchBytes.Observe(float64(buf.Len()))
 
+	start := time.Now()
 	res, err := elasticsearch.Client.Bulk(
 		strings.NewReader(buf.String()),
 		elasticsearch.Client.Bulk.WithContext(ctx),
-		elasticsearch.Client.Bulk.WithRefresh("true"),
+		elasticsearch.Client.Bulk.WithRefresh(refresh),
 	)
+	metrics.BulkDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		log.Printf("[Batch] Error executing bulk request: %v", err)
-		return fmt.Errorf("failed to execute bulk request: %v", err)
+		return nil, nil, fmt.Errorf("failed to execute bulk request: %v", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		var raw map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&raw); err != nil {
-			log.Printf("[Batch] Error decoding error response: %v", err)
-			return fmt.Errorf("bulk indexing failed: %s", res.String())
-		}
-
-		if errors, ok := raw["errors"].(bool); ok && errors {
-			if items, ok := raw["items"].([]interface{}); ok {
-				for i, item := range items {
-					if index, ok := item.(map[string]interface{})["index"].(map[string]interface{}); ok {
-						if err, ok := index["error"].(map[string]interface{}); ok {
-							log.Printf("[Batch] Document %d indexing error: %v", i, err)
-						}
-					}
-				}
-			}
-		}
-		return fmt.Errorf("bulk indexing failed: %s", res.String())
+		log.Printf("[Batch] Bulk request failed: %s", res.String())
+		return nil, nil, &bulkError{status: res.StatusCode, body: res.String()}
 	}
 
 	var response map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
 		log.Printf("[Batch] Error decoding success response: %v", err)
-		return fmt.Errorf("failed to decode bulk response: %v", err)
+		return nil, nil, fmt.Errorf("failed to decode bulk response: %v", err)
 	}
 
+	// Items come back in request order, one per document.
+	results := make([]string, len(docs))
+	var failures []itemFailure
 	if items, ok := response["items"].([]interface{}); ok {
 		for i, item := range items {
-			if index, ok := item.(map[string]interface{})["index"].(map[string]interface{}); ok {
-				if err, ok := index["error"].(map[string]interface{}); ok {
-					log.Printf("[Batch] Document %d indexing error: %v", i, err)
+			if index, ok := item.(map[string]interface{})["index"].(map[string]interface{}); ok && i < len(docs) {
+				results[i], _ = index["result"].(string)
+				if itemErr, ok := index["error"].(map[string]interface{}); ok {
+					log.Printf("[Batch] Document %s indexing error: %v", docs[i].ID, itemErr)
+					status, _ := index["status"].(float64)
+					reason, _ := itemErr["reason"].(string)
+					if errType, ok := itemErr["type"].(string); ok {
+						reason = errType + ": " + reason
+					}
+					failures = append(failures, itemFailure{index: i, status: int(status), reason: reason})
 				}
 			}
 		}
@@ -163,11 +598,23 @@ func (bp *BatchProcessor) Flush() error {
 		log.Printf("[Batch] Updated document count to %d", count)
 	}
 
-	log.Printf("[Batch] Successfully indexed %d documents", len(docs))
-	return nil
+	log.Printf("[Batch] Successfully indexed %d documents", len(docs)-len(failures))
+	return results, failures, nil
 }
 
-
+// Stop stops the workers, sends what is due one last time and closes
+// the write-ahead log. Documents still waiting for a retry stay in the
+// log for the next start.
 func (bp *BatchProcessor) Stop() {
 	close(bp.stopChan)
-} 
\ No newline at end of file
+	bp.wg.Wait()
+
+	if err := bp.Flush(); err != nil {
+		log.Printf("[Batch] Error during final flush: %v", err)
+	}
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	if bp.wal != nil {
+		bp.wal.close()
+	}
+}
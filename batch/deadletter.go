@@ -0,0 +1,194 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shallowseek/models"
+)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a document Elasticsearch refused for good, or kept
+// refusing through every retry. It stays here until an operator fixes
+// and requeues it or deletes it.
+type DeadLetter struct {
+	Document models.Document `json:"document"`
+	Error    string          `json:"error"`
+	Status   int             `json:"status,omitempty"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// deadLetterStore keeps one JSON file per document in a directory.
+type deadLetterStore struct {
+	dir string
+}
+
+func openDeadLetterStore(dir string) (*deadLetterStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter directory: %v", err)
+	}
+	return &deadLetterStore{dir: dir}, nil
+}
+
+func (s *deadLetterStore) path(id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", ErrDeadLetterNotFound
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// put writes an entry through a temporary file, so a crash never leaves
+// half an entry behind.
+func (s *deadLetterStore) put(dl DeadLetter) error {
+	path, err := s.path(dl.Document.ID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter %s: %v", dl.Document.ID, err)
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write dead letter: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write dead letter: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync dead letter: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write dead letter: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write dead letter: %v", err)
+	}
+	return nil
+}
+
+func (s *deadLetterStore) get(id string) (*DeadLetter, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter: %v", err)
+	}
+	var dl DeadLetter
+	if err := json.Unmarshal(data, &dl); err != nil {
+		return nil, fmt.Errorf("failed to parse dead letter %s: %v", id, err)
+	}
+	return &dl, nil
+}
+
+// list returns every entry, most recent failure first.
+func (s *deadLetterStore) list() ([]DeadLetter, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letter directory: %v", err)
+	}
+
+	var letters []DeadLetter
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		dl, err := s.get(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, *dl)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.After(letters[j].FailedAt) })
+	return letters, nil
+}
+
+func (s *deadLetterStore) remove(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return ErrDeadLetterNotFound
+	} else if err != nil {
+		return fmt.Errorf("failed to remove dead letter: %v", err)
+	}
+	return nil
+}
+
+var errNoDeadLetterStore = errors.New("dead letter store is not available")
+
+// DeadLetters lists the documents in the dead letter store.
+func (bp *BatchProcessor) DeadLetters() ([]DeadLetter, error) {
+	if bp.deadLetters == nil {
+		return nil, errNoDeadLetterStore
+	}
+	return bp.deadLetters.list()
+}
+
+func (bp *BatchProcessor) DeadLetter(id string) (*DeadLetter, error) {
+	if bp.deadLetters == nil {
+		return nil, errNoDeadLetterStore
+	}
+	return bp.deadLetters.get(id)
+}
+
+// FixDeadLetter replaces the stored document, for example with a field
+// Elasticsearch could not parse corrected, and keeps it in the store
+// until it is requeued.
+func (bp *BatchProcessor) FixDeadLetter(id string, doc models.Document) (*DeadLetter, error) {
+	if bp.deadLetters == nil {
+		return nil, errNoDeadLetterStore
+	}
+	dl, err := bp.deadLetters.get(id)
+	if err != nil {
+		return nil, err
+	}
+	doc.ID = id
+	dl.Document = doc
+	if err := bp.deadLetters.put(*dl); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+// RequeueDeadLetter moves a document back to the batch queue with a
+// fresh set of attempts.
+func (bp *BatchProcessor) RequeueDeadLetter(id string) error {
+	if bp.deadLetters == nil {
+		return errNoDeadLetterStore
+	}
+	dl, err := bp.deadLetters.get(id)
+	if err != nil {
+		return err
+	}
+	if err := bp.AddDocument(dl.Document); err != nil {
+		return err
+	}
+	return bp.deadLetters.remove(id)
+}
+
+func (bp *BatchProcessor) DeleteDeadLetter(id string) error {
+	if bp.deadLetters == nil {
+		return errNoDeadLetterStore
+	}
+	return bp.deadLetters.remove(id)
+}
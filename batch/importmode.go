@@ -0,0 +1,173 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shallowseek/config"
+	"github.com/shallowseek/elasticsearch"
+)
+
+var ErrNoImport = errors.New("no import in progress")
+
+// importSettings are the index settings bulk import mode changes.
+var importSettings = map[string]string{
+	"index.refresh_interval":   "-1",
+	"index.number_of_replicas": "0",
+}
+
+// importState is kept on disk while an import runs, so the settings it
+// replaced can be restored even after a crash.
+type importState struct {
+	Settings map[string]string `json:"settings"`
+	Started  time.Time         `json:"started"`
+}
+
+// ImportStatus describes the bulk import mode.
+type ImportStatus struct {
+	Importing bool       `json:"importing"`
+	Imports   int        `json:"imports"`
+	Started   *time.Time `json:"started,omitempty"`
+}
+
+// BeginImport switches the index to bulk import mode: no periodic
+// refresh and no replicas, and bulk requests never ask for a refresh.
+// Imports nest, and the settings are restored when the last one ends.
+func (bp *BatchProcessor) BeginImport() error {
+	bp.importMu.Lock()
+	defer bp.importMu.Unlock()
+
+	if bp.imports == 0 {
+		state, err := readImportState()
+		if err != nil {
+			return err
+		}
+		// A state file left behind means the index still has the import
+		// settings, so the ones saved in it are the ones to restore.
+		if state == nil {
+			settings, err := elasticsearch.GetIndexSettings("index.refresh_interval", "index.number_of_replicas")
+			if err != nil {
+				return fmt.Errorf("failed to read index settings: %v", err)
+			}
+			state = &importState{Settings: settings, Started: time.Now()}
+			if err := writeImportState(state); err != nil {
+				return err
+			}
+		}
+		if err := elasticsearch.UpdateIndexSettings(importSettings); err != nil {
+			return fmt.Errorf("failed to update index settings: %v", err)
+		}
+		bp.importStarted = state.Started
+		bp.importOn.Store(true)
+		log.Printf("[Batch] Bulk import mode on (saved settings: %v)", state.Settings)
+	}
+
+	bp.imports++
+	return nil
+}
+
+// EndImport ends one import. When it was the last, the queued documents
+// are sent, the saved settings restored and the index refreshed.
+func (bp *BatchProcessor) EndImport() error {
+	bp.importMu.Lock()
+	defer bp.importMu.Unlock()
+
+	if bp.imports > 1 {
+		bp.imports--
+		return nil
+	}
+	if bp.imports == 0 {
+		if state, err := readImportState(); err != nil || state == nil {
+			return ErrNoImport
+		}
+	}
+	bp.imports = 0
+
+	if err := bp.Flush(); err != nil {
+		log.Printf("[Batch] Error flushing at end of import: %v", err)
+	}
+	return bp.restoreIndexSettings()
+}
+
+// RestoreIndexSettings puts back the settings of an import that was
+// running when the server stopped. It is called once Elasticsearch is
+// reachable at startup.
+func (bp *BatchProcessor) RestoreIndexSettings() error {
+	bp.importMu.Lock()
+	defer bp.importMu.Unlock()
+
+	if bp.imports > 0 {
+		return nil
+	}
+	return bp.restoreIndexSettings()
+}
+
+// restoreIndexSettings is called with bp.importMu held.
+func (bp *BatchProcessor) restoreIndexSettings() error {
+	state, err := readImportState()
+	if err != nil || state == nil {
+		return err
+	}
+
+	if err := elasticsearch.UpdateIndexSettings(state.Settings); err != nil {
+		return fmt.Errorf("failed to restore index settings: %v", err)
+	}
+	if err := elasticsearch.RefreshIndex(); err != nil {
+		log.Printf("[Batch] Error refreshing index after import: %v", err)
+	}
+	// The imported documents are only searchable now.
+	invalidateCache("true")
+	bp.importOn.Store(false)
+	if err := os.Remove(config.GetImportStateFile()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove import state: %v", err)
+	}
+	log.Printf("[Batch] Bulk import mode off (restored settings: %v)", state.Settings)
+	return nil
+}
+
+func (bp *BatchProcessor) ImportStatus() ImportStatus {
+	bp.importMu.Lock()
+	defer bp.importMu.Unlock()
+
+	status := ImportStatus{Importing: bp.importOn.Load(), Imports: bp.imports}
+	if status.Importing {
+		started := bp.importStarted
+		status.Started = &started
+	}
+	return status
+}
+
+func readImportState() (*importState, error) {
+	data, err := os.ReadFile(config.GetImportStateFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import state: %v", err)
+	}
+	var state importState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse import state: %v", err)
+	}
+	return &state, nil
+}
+
+func writeImportState(state *importState) error {
+	path := config.GetImportStateFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to write import state: %v", err)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write import state: %v", err)
+	}
+	return nil
+}
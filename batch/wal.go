@@ -0,0 +1,222 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shallowseek/models"
+)
+
+const (
+	walSuffix = ".wal"
+
+	// maxSegmentBytes is the size at which the log starts a new segment,
+	// so the space of indexed documents is given back as they go.
+	maxSegmentBytes = 64 << 20
+)
+
+// wal is the write-ahead log behind the batch queue. Documents are
+// appended to the current segment and synced to disk before AddDocument
+// returns. Each segment counts the queued documents it holds, and is
+// removed once Elasticsearch has indexed or refused all of them and
+// writing has moved on to a newer segment. After a crash, documents
+// already indexed from a segment that was still in use are indexed
+// again, which is harmless as documents are indexed by ID.
+//
+// A wal is not safe for concurrent use; the BatchProcessor serialises
+// access to it.
+type wal struct {
+	dir  string
+	seq  uint64
+	file *os.File
+	size int64
+	refs map[uint64]int
+}
+
+// walRecord is a document read back from the log.
+type walRecord struct {
+	doc     models.Document
+	segment uint64
+	size    int
+}
+
+// openWAL opens the log in dir and returns the documents left in it by
+// a previous run, in the order they were added.
+func openWAL(dir string) (*wal, []walRecord, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create WAL directory: %v", err)
+	}
+
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A document requeued from the dead letter store is logged again, so
+	// keep the latest copy in the position it was first added.
+	var records []walRecord
+	positions := make(map[string]int)
+	for _, seq := range segments {
+		segmentRecords, err := readSegment(dir, seq)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, record := range segmentRecords {
+			if i, ok := positions[record.doc.ID]; ok {
+				records[i] = record
+				continue
+			}
+			positions[record.doc.ID] = len(records)
+			records = append(records, record)
+		}
+	}
+
+	w := &wal{dir: dir, refs: make(map[uint64]int)}
+	for _, seq := range segments {
+		w.refs[seq] = 0
+	}
+	for _, record := range records {
+		w.refs[record.segment]++
+	}
+	if len(segments) > 0 {
+		w.seq = segments[len(segments)-1]
+	}
+	if err := w.rotate(); err != nil {
+		return nil, nil, err
+	}
+	for _, seq := range segments {
+		if w.refs[seq] == 0 {
+			w.remove(seq)
+		}
+	}
+	return w, records, nil
+}
+
+// append writes a marshaled document to the current segment, syncs it
+// and returns the segment, to be released once the document is done.
+func (w *wal) append(data []byte) (uint64, error) {
+	if _, err := w.file.Write(append(data, '\n')); err != nil {
+		return 0, fmt.Errorf("failed to write WAL: %v", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync WAL: %v", err)
+	}
+
+	seq := w.seq
+	w.refs[seq]++
+	w.size += int64(len(data) + 1)
+	if w.size >= maxSegmentBytes {
+		if err := w.rotate(); err != nil {
+			// Keep writing to the full segment; it only grows.
+			log.Printf("[Batch] Error starting new WAL segment: %v", err)
+		}
+	}
+	return seq, nil
+}
+
+// release marks one document of a segment as done.
+func (w *wal) release(seq uint64) {
+	w.refs[seq]--
+	if w.refs[seq] <= 0 && seq != w.seq {
+		w.remove(seq)
+	}
+}
+
+// rotate closes the current segment and starts a new one.
+func (w *wal) rotate() error {
+	f, err := os.OpenFile(filepath.Join(w.dir, segmentName(w.seq+1)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL segment: %v", err)
+	}
+
+	prev := w.seq
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file = f
+	w.seq++
+	w.size = 0
+	w.refs[w.seq] = 0
+	if refs, ok := w.refs[prev]; ok && refs <= 0 {
+		w.remove(prev)
+	}
+	return nil
+}
+
+func (w *wal) remove(seq uint64) {
+	delete(w.refs, seq)
+	if err := os.Remove(filepath.Join(w.dir, segmentName(seq))); err != nil && !os.IsNotExist(err) {
+		log.Printf("[Batch] Error removing WAL segment: %v", err)
+	}
+}
+
+func (w *wal) close() error {
+	return w.file.Close()
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, walSuffix)
+}
+
+// walSegments lists the sequence numbers of the segments in dir in
+// ascending order.
+func walSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL directory: %v", err)
+	}
+
+	var segments []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, walSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
+
+// readSegment reads the documents of one segment. A crash can leave the
+// last line half written; it was never acknowledged, so it is skipped.
+func readSegment(dir string, seq uint64) ([]walRecord, error) {
+	f, err := os.Open(filepath.Join(dir, segmentName(seq)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL segment: %v", err)
+	}
+	defer f.Close()
+
+	var records []walRecord
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var doc models.Document
+			if jsonErr := json.Unmarshal(line, &doc); jsonErr != nil {
+				log.Printf("[Batch] Skipping unreadable WAL record in %s: %v", segmentName(seq), jsonErr)
+			} else {
+				records = append(records, walRecord{doc: doc, segment: seq, size: len(line)})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read WAL segment: %v", err)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// breaker stops calls to Redis once it has failed threshold times in a
+// row, so searches don't each wait for a timeout while it is down. After
+// cooldown one call is let through to try it again: if it succeeds the
+// breaker closes, otherwise it stays open for another cooldown.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	openUntil time.Time
+	trying    bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may be made now.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.trying || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trying = true
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		log.Printf("[Cache] Redis is back, closing circuit breaker")
+	}
+	b.failures = 0
+	b.open = false
+	b.trying = false
+}
+
+func (b *breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if !b.open && b.failures < b.threshold {
+		return
+	}
+	if !b.open {
+		log.Printf("[Cache] Redis failed %d times in a row, opening circuit breaker for %v: %v", b.failures, b.cooldown, err)
+	}
+	b.open = true
+	b.trying = false
+	b.openUntil = time.Now().Add(b.cooldown)
+}
+
+// trip opens the breaker at once, such as when Redis can't be reached at
+// startup.
+func (b *breaker) trip(err error) {
+	b.mu.Lock()
+	b.failures = b.threshold - 1
+	b.mu.Unlock()
+	b.failure(err)
+}
+
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
@@ -3,6 +3,10 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -10,51 +14,158 @@ import (
 	"github.com/shallowseek/models"
 )
 
+// generationKey holds the index generation. Every write to the index
+// moves it on, and cached results are keyed by it, so results cached
+// before a write are never served after it.
+const generationKey = "search:generation"
+
+// evictionsInterval is how often Redis is asked how many keys it evicted.
+const evictionsInterval = 30 * time.Second
+
+// Tier is one level of the cache. Get returns nil without an error on a
+// miss.
+type Tier interface {
+	Name() string
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+	Stats() TierStats
+}
+
 var (
-	redisClient *redis.Client
-	ctx         = context.Background()
+	// local is in front of shared, which is nil when Redis is turned off.
+	local  *lru
+	shared *redisTier
+
+	// localGeneration stands in for the shared generation while Redis
+	// can't be reached, or on installs without it.
+	localGeneration atomic.Int64
 )
 
+// Init sets up the in-process tier and, unless CACHE_REDIS is false, the
+// Redis tier behind it. An error means Redis can't be reached; the cache
+// then works in process until it can.
 func Init() error {
-	redisClient = redis.NewClient(&redis.Options{
-		Addr:     config.GetRedisURL(),
-		Password: "",
-		DB:       0,
-	})
+	local = newLRU(config.GetCacheLocalTTL(), config.GetCacheLocalEntries(), config.GetCacheLocalBytes())
+	if !config.GetCacheRedis() {
+		log.Printf("[Cache] Redis turned off, caching in process only")
+		return nil
+	}
+
+	timeout := config.GetCacheRedisTimeout()
+	shared = &redisTier{
+		client: redis.NewClient(&redis.Options{
+			Addr:         config.GetRedisURL(),
+			Password:     "",
+			DB:           0,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+			// The circuit breaker decides when to try again.
+			MaxRetries: -1,
+		}),
+		ttl:     config.GetCacheTTL(),
+		timeout: timeout,
+		breaker: newBreaker(config.GetCacheBreakerFailures(), config.GetCacheBreakerCooldown()),
+		stats:   tierStats{name: "redis"},
+	}
+	go shared.watchEvictions(evictionsInterval)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := shared.client.Ping(ctx).Err(); err != nil {
+		shared.breaker.trip(err)
+		return err
+	}
+	return nil
+}
+
+// Generation returns the current index generation, read before
+// searching and passed back with the results to cache. It is the one
+// shared through Redis, or the server's own while Redis is unavailable.
+func Generation() string {
+	if shared != nil {
+		if generation, err := shared.generation(); err == nil {
+			return fmt.Sprintf("r%d", generation)
+		}
+	}
+	return fmt.Sprintf("l%d", localGeneration.Load())
+}
+
+// BumpGeneration moves the index generation on after documents were
+// indexed or deleted, so no result cached before is served again.
+func BumpGeneration() {
+	localGeneration.Add(1)
+	if shared != nil {
+		if err := shared.bumpGeneration(); err != nil && err != errUnavailable {
+			log.Printf("[Cache] Error moving the shared generation on: %v", err)
+		}
+	}
+}
 
-	_, err := redisClient.Ping(ctx).Result()
-	return err
+// tiers returns the tiers results of a generation are kept in, nearest
+// first. Results of the server's own generation stay in process.
+func tiers(generation string) []Tier {
+	var result []Tier
+	if local != nil {
+		result = append(result, local)
+	}
+	if shared != nil && strings.HasPrefix(generation, "r") {
+		result = append(result, shared)
+	}
+	return result
 }
 
-func CacheSearchResult(query string, results models.SimplifiedSearchResult) error {
-	key := "search:" + query
+func searchKey(generation, query string) string {
+	return "search:" + generation + ":" + query
+}
+
+func CacheSearchResult(generation, query string, results models.SimplifiedSearchResult) error {
 	data, err := json.Marshal(results)
 	if err != nil {
 		return err
 	}
 
-	return redisClient.Set(ctx, key, data, 5*time.Minute).Err()
+	key := searchKey(generation, query)
+	var failed error
+	for _, tier := range tiers(generation) {
+		if err := tier.Set(key, data); err != nil && err != errUnavailable {
+			failed = fmt.Errorf("%s: %v", tier.Name(), err)
+		}
+	}
+	return failed
 }
 
-func GetCachedSearchResult(query string) (*models.SimplifiedSearchResult, error) {
-	key := "search:" + query
-	data, err := redisClient.Get(ctx, key).Bytes()
-	if err != nil {
-		if err == redis.Nil {
-			return nil, nil
+// GetCachedSearchResult looks results up tier by tier, copying a hit in
+// a farther tier to the nearer ones.
+func GetCachedSearchResult(generation, query string) (*models.SimplifiedSearchResult, error) {
+	key := searchKey(generation, query)
+	all := tiers(generation)
+	for i, tier := range all {
+		data, err := tier.Get(key)
+		if err != nil || data == nil {
+			continue
 		}
-		return nil, err
-	}
 
-	var results models.SimplifiedSearchResult
-	if err := json.Unmarshal(data, &results); err != nil {
-		return nil, err
+		var results models.SimplifiedSearchResult
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, err
+		}
+		for _, nearer := range all[:i] {
+			nearer.Set(key, data)
+		}
+		return &results, nil
 	}
-
-	return &results, nil
+	return nil, nil
 }
 
-func InvalidateCache(query string) error {
-	key := "search:" + query
-	return redisClient.Del(ctx, key).Err()
-} 
\ No newline at end of file
+func InvalidateCache(generation, query string) error {
+	key := searchKey(generation, query)
+	var failed error
+	for _, tier := range tiers(generation) {
+		if err := tier.Delete(key); err != nil && err != errUnavailable {
+			failed = fmt.Errorf("%s: %v", tier.Name(), err)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is the in-process tier: the most recently used entries, up to a
+// number of entries and of bytes, each kept for ttl.
+type lru struct {
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int
+	stats      tierStats
+
+	mu    sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+	bytes int
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newLRU(ttl time.Duration, maxEntries, maxBytes int) *lru {
+	return &lru{
+		stats:      tierStats{name: "local"},
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *lru) Name() string {
+	return "local"
+}
+
+func (c *lru) Get(key string) ([]byte, error) {
+	defer c.stats.observe("get", time.Now())
+	value := c.get(key)
+	if value == nil {
+		c.stats.miss()
+	} else {
+		c.stats.hit()
+	}
+	return value, nil
+}
+
+func (c *lru) get(key string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(el)
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return entry.value
+}
+
+func (c *lru) Set(key string, value []byte) error {
+	defer c.stats.observe("set", time.Now())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	// An entry bigger than the whole tier would only evict everything.
+	if len(value) > c.maxBytes {
+		return nil
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: time.Now().Add(c.ttl)})
+	c.bytes += len(value)
+	evicted := 0
+	for c.order.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.removeElement(c.order.Back())
+		evicted++
+	}
+	if evicted > 0 {
+		c.stats.evicted(int64(evicted))
+	}
+	return nil
+}
+
+func (c *lru) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	return nil
+}
+
+// deleteMatching deletes the entries whose key match reports true and
+// returns how many there were.
+func (c *lru) deleteMatching(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deleted := 0
+	for key, el := range c.items {
+		if match(key) {
+			c.removeElement(el)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (c *lru) Stats() TierStats {
+	stats := c.stats.snapshot(c.ttl)
+	stats.Available = true
+	stats.MaxEntries = c.maxEntries
+	stats.MaxBytes = c.maxBytes
+	c.mu.Lock()
+	stats.Entries = c.order.Len()
+	stats.Bytes = c.bytes
+	c.mu.Unlock()
+	return stats
+}
+
+// removeElement is called with c.mu held.
+func (c *lru) removeElement(el *list.Element) {
+	entry := c.order.Remove(el).(*lruEntry)
+	delete(c.items, entry.key)
+	c.bytes -= len(entry.value)
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// errUnavailable is returned instead of calling Redis while the circuit
+// breaker is open.
+var errUnavailable = errors.New("redis unavailable")
+
+// redisTier is the shared tier, which every server of an installation
+// reads and writes. It also holds the index generation they share.
+type redisTier struct {
+	client  *redis.Client
+	ttl     time.Duration
+	timeout time.Duration
+	breaker *breaker
+	stats   tierStats
+
+	// behind is set when the generation couldn't be moved on, so that
+	// is done first once Redis is back.
+	behind atomic.Bool
+}
+
+func (t *redisTier) Name() string {
+	return "redis"
+}
+
+func (t *redisTier) Get(key string) ([]byte, error) {
+	defer t.stats.observe("get", time.Now())
+	var data []byte
+	err := t.do(func(ctx context.Context) error {
+		var err error
+		data, err = t.client.Get(ctx, key).Bytes()
+		return err
+	})
+	switch {
+	case err == redis.Nil:
+		t.stats.miss()
+		return nil, nil
+	case err != nil:
+		t.stats.fail()
+		return nil, err
+	}
+	t.stats.hit()
+	return data, nil
+}
+
+func (t *redisTier) Set(key string, value []byte) error {
+	defer t.stats.observe("set", time.Now())
+	err := t.do(func(ctx context.Context) error {
+		return t.client.Set(ctx, key, value, t.ttl).Err()
+	})
+	if err != nil {
+		t.stats.fail()
+	}
+	return err
+}
+
+func (t *redisTier) Delete(key string) error {
+	return t.do(func(ctx context.Context) error {
+		return t.client.Del(ctx, key).Err()
+	})
+}
+
+// deleteMatching deletes the search results whose key match reports
+// true and returns how many there were. Keys are scanned a batch at a
+// time, each call going through the circuit breaker.
+func (t *redisTier) deleteMatching(match func(key string) bool) (int, error) {
+	deleted := 0
+	var cursor uint64
+	for {
+		var keys []string
+		err := t.do(func(ctx context.Context) error {
+			var err error
+			keys, cursor, err = t.client.Scan(ctx, cursor, "search:*", 500).Result()
+			return err
+		})
+		if err != nil {
+			return deleted, err
+		}
+
+		var matched []string
+		for _, key := range keys {
+			if key != generationKey && match(key) {
+				matched = append(matched, key)
+			}
+		}
+		if len(matched) > 0 {
+			var n int64
+			err := t.do(func(ctx context.Context) error {
+				var err error
+				n, err = t.client.Del(ctx, matched...).Result()
+				return err
+			})
+			deleted += int(n)
+			if err != nil {
+				return deleted, err
+			}
+		}
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// watchEvictions counts the keys Redis evicts for memory, which it only
+// reports in INFO, every interval. Evictions from before the server
+// started are not counted.
+func (t *redisTier) watchEvictions(interval time.Duration) {
+	previous := int64(-1)
+	for range time.Tick(interval) {
+		var info string
+		err := t.do(func(ctx context.Context) error {
+			var err error
+			info, err = t.client.Info(ctx, "stats").Result()
+			return err
+		})
+		if err != nil {
+			if err != errUnavailable {
+				log.Printf("[Cache] Error reading Redis stats: %v", err)
+			}
+			continue
+		}
+		evicted, ok := infoField(info, "evicted_keys")
+		if !ok {
+			continue
+		}
+		switch {
+		case previous < 0:
+		case evicted < previous:
+			// A restarted Redis counts from zero again.
+			t.stats.evicted(evicted)
+		case evicted > previous:
+			t.stats.evicted(evicted - previous)
+		}
+		previous = evicted
+	}
+}
+
+// infoField returns a number from the output of INFO.
+func infoField(info, name string) (int64, bool) {
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), name+":"); ok {
+			n, err := strconv.ParseInt(value, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+func (t *redisTier) Stats() TierStats {
+	stats := t.stats.snapshot(t.ttl)
+	stats.Available = !t.breaker.isOpen()
+	return stats
+}
+
+// generation returns the shared index generation, first moving it on if
+// a write happened while Redis couldn't be told.
+func (t *redisTier) generation() (int64, error) {
+	if t.behind.Load() {
+		if err := t.bumpGeneration(); err != nil {
+			return 0, err
+		}
+	}
+
+	var generation int64
+	err := t.do(func(ctx context.Context) error {
+		var err error
+		generation, err = t.client.Get(ctx, generationKey).Int64()
+		return err
+	})
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return generation, err
+}
+
+func (t *redisTier) bumpGeneration() error {
+	err := t.do(func(ctx context.Context) error {
+		return t.client.Incr(ctx, generationKey).Err()
+	})
+	t.behind.Store(err != nil)
+	return err
+}
+
+// do makes a call to Redis through the circuit breaker. A miss is not a
+// failure.
+func (t *redisTier) do(call func(ctx context.Context) error) error {
+	if !t.breaker.allow() {
+		return errUnavailable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	err := call(ctx)
+	if err != nil && err != redis.Nil {
+		t.breaker.failure(err)
+	} else {
+		t.breaker.success()
+	}
+	return err
+}
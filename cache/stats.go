@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/shallowseek/metrics"
+)
+
+// Stats is what the admin API shows of the cache.
+type Stats struct {
+	Generation string      `json:"generation"`
+	Tiers      []TierStats `json:"tiers"`
+}
+
+// TierStats are the counts of one tier since the server started.
+type TierStats struct {
+	Name       string  `json:"name"`
+	Available  bool    `json:"available"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	Errors     int64   `json:"errors"`
+	Evictions  int64   `json:"evictions"`
+	HitRatio   float64 `json:"hit_ratio"`
+	Entries    int     `json:"entries,omitempty"`
+	Bytes      int     `json:"bytes,omitempty"`
+	MaxEntries int     `json:"max_entries,omitempty"`
+	MaxBytes   int     `json:"max_bytes,omitempty"`
+	TTL        string  `json:"ttl"`
+}
+
+// tierStats counts what a tier does, both for the admin API and for
+// Prometheus.
+type tierStats struct {
+	name      string
+	hits      atomic.Int64
+	misses    atomic.Int64
+	errors    atomic.Int64
+	evictions atomic.Int64
+}
+
+func (s *tierStats) hit() {
+	s.hits.Add(1)
+	metrics.CacheHits.WithLabelValues(s.name).Inc()
+}
+
+func (s *tierStats) miss() {
+	s.misses.Add(1)
+	metrics.CacheMisses.WithLabelValues(s.name).Inc()
+}
+
+func (s *tierStats) fail() {
+	s.errors.Add(1)
+	metrics.CacheErrors.WithLabelValues(s.name).Inc()
+}
+
+func (s *tierStats) evicted(n int64) {
+	s.evictions.Add(n)
+	metrics.CacheEvictions.WithLabelValues(s.name).Add(float64(n))
+}
+
+func (s *tierStats) observe(operation string, start time.Time) {
+	metrics.CacheDuration.WithLabelValues(s.name, operation).Observe(time.Since(start).Seconds())
+}
+
+func (s *tierStats) snapshot(ttl time.Duration) TierStats {
+	stats := TierStats{
+		Name:      s.name,
+		Hits:      s.hits.Load(),
+		Misses:    s.misses.Load(),
+		Errors:    s.errors.Load(),
+		Evictions: s.evictions.Load(),
+		TTL:       ttl.String(),
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
+
+// GetStats returns the counts of every tier.
+func GetStats() Stats {
+	stats := Stats{Generation: Generation()}
+	for _, tier := range tiers("r") {
+		stats.Tiers = append(stats.Tiers, tier.Stats())
+	}
+	return stats
+}
+
+// Flush deletes cached results, all of them when pattern is empty, and
+// otherwise those of the queries pattern matches, whatever their sort
+// and ranges. Queries are matched in their normalized form, lowercase
+// with their filters last, so pattern must be normalized the same way;
+// "*" in it matches any text and "?" any one character. It returns how
+// many entries were deleted.
+func Flush(pattern string) (int, error) {
+	match := func(string) bool { return true }
+	if pattern == "" {
+		// Searches already running cache under the old generation, which
+		// no one reads again.
+		BumpGeneration()
+	} else {
+		re := globPattern(pattern)
+		match = re.MatchString
+	}
+	matchKey := func(key string) bool {
+		query, ok := queryOfKey(key)
+		return ok && match(query)
+	}
+
+	deleted := 0
+	if local != nil {
+		deleted += local.deleteMatching(matchKey)
+	}
+	if shared != nil {
+		n, err := shared.deleteMatching(matchKey)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// queryOfKey returns the query of a search key, without its generation
+// and without the parameters that follow its last "|".
+func queryOfKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, "search:")
+	if !ok {
+		return "", false
+	}
+	_, query, ok := strings.Cut(rest, ":")
+	if i := strings.LastIndex(query, "|"); i >= 0 {
+		query = query[:i]
+	}
+	return query, ok
+}
+
+// globPattern turns a pattern where "*" matches anything and "?" one
+// character into a regular expression matching whole queries.
+func globPattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlushPattern(t *testing.T) {
+	local = newLRU(time.Minute, 100, 1<<20)
+	t.Cleanup(func() { local = nil })
+
+	keys := map[string]bool{
+		"отчёт за квартал|":                        true,
+		"отчёт за квартал|sort=-created":           true,
+		"отчёт|created_from=2024-01-01&sort=title": true,
+		"годовой отчёт|":                           false,
+		"отчёт a|b|sort=title":                     true,
+		`план from:"анна"|`:                        false,
+	}
+	for query := range keys {
+		local.Set(searchKey("l1", query), []byte("{}"))
+	}
+
+	deleted, err := Flush("отчёт*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 4 {
+		t.Errorf("Flush deleted %d entries, want 4", deleted)
+	}
+	for query, flushed := range keys {
+		if cached := local.get(searchKey("l1", query)) != nil; cached == flushed {
+			t.Errorf("%q cached = %v after flush", query, cached)
+		}
+	}
+}
@@ -2,15 +2,13 @@ package config
 
 import (
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
-var (
-	StartTime = time.Now()
-	APIKeys   = map[string]bool{
-		"test-key": true,
-	}
-)
+var StartTime = time.Now()
 
 func GetPort() string {
 	port := os.Getenv("PORT")
@@ -34,4 +32,390 @@ func GetRedisURL() string {
 		return "localhost:6379"
 	}
 	return url
-} 
\ No newline at end of file
+}
+
+// GetCacheRedis reports whether search results are cached in Redis too,
+// as they are unless CACHE_REDIS is "false".
+func GetCacheRedis() bool {
+	return os.Getenv("CACHE_REDIS") != "false"
+}
+
+// GetCacheTTL returns how long Redis keeps search results.
+func GetCacheTTL() time.Duration {
+	return getEnvDuration("CACHE_TTL", 5*time.Minute)
+}
+
+// GetCacheLocalTTL returns how long the in-process cache keeps search
+// results.
+func GetCacheLocalTTL() time.Duration {
+	return getEnvDuration("CACHE_LOCAL_TTL", time.Minute)
+}
+
+func GetCacheLocalEntries() int {
+	return getEnvInt("CACHE_LOCAL_ENTRIES", 1000)
+}
+
+func GetCacheLocalBytes() int {
+	return getEnvInt("CACHE_LOCAL_BYTES", 64<<20)
+}
+
+// GetCacheRedisTimeout returns how long a Redis call may take before it
+// counts as failed.
+func GetCacheRedisTimeout() time.Duration {
+	return getEnvDuration("CACHE_REDIS_TIMEOUT", 200*time.Millisecond)
+}
+
+// GetCacheBreakerFailures returns how many Redis calls in a row must
+// fail before the cache stops calling it.
+func GetCacheBreakerFailures() int {
+	return getEnvInt("CACHE_BREAKER_FAILURES", 5)
+}
+
+// GetCacheBreakerCooldown returns how long the cache leaves Redis alone
+// before trying it again.
+func GetCacheBreakerCooldown() time.Duration {
+	return getEnvDuration("CACHE_BREAKER_COOLDOWN", 30*time.Second)
+}
+
+// GetQueryLogFile returns where searches are logged, for pre-warming
+// the cache with the most frequent ones.
+func GetQueryLogFile() string {
+	path := os.Getenv("QUERY_LOG_FILE")
+	if path == "" {
+		return "data/queries.log"
+	}
+	return path
+}
+
+// GetQueryLogMaxBytes returns the size at which the query log is
+// rotated.
+func GetQueryLogMaxBytes() int64 {
+	return int64(getEnvInt("QUERY_LOG_MAX_BYTES", 64<<20))
+}
+
+func GetOCRLanguages() string {
+	langs := os.Getenv("OCR_LANGUAGES")
+	if langs == "" {
+		return "rus+eng"
+	}
+	return langs
+}
+
+// GetChatWindow returns how far apart messages of a chat export may be
+// and still be indexed as one conversation window. 0, the default,
+// indexes every message on its own.
+func GetChatWindow() time.Duration {
+	return getEnvInterval("CHAT_WINDOW", 0)
+}
+
+// GetChatWindowMessages returns the most messages a conversation window
+// holds.
+func GetChatWindowMessages() int {
+	return getEnvInt("CHAT_WINDOW_MESSAGES", 50)
+}
+
+func GetWALDir() string {
+	dir := os.Getenv("WAL_DIR")
+	if dir == "" {
+		return "data/wal"
+	}
+	return dir
+}
+
+func GetDeadLetterDir() string {
+	dir := os.Getenv("DEAD_LETTER_DIR")
+	if dir == "" {
+		return "data/deadletter"
+	}
+	return dir
+}
+
+func GetExtractWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv("EXTRACT_WORKERS"))
+	if err != nil || workers < 1 {
+		return runtime.NumCPU()
+	}
+	return workers
+}
+
+func GetBulkWorkers() int {
+	return getEnvInt("BULK_WORKERS", 2)
+}
+
+// GetBulkBatchSize and GetBulkBatchBytes bound a single bulk request;
+// a batch is sent as soon as it reaches either.
+func GetBulkBatchSize() int {
+	return getEnvInt("BULK_BATCH_SIZE", 100)
+}
+
+func GetBulkBatchBytes() int {
+	return getEnvInt("BULK_BATCH_BYTES", 10<<20)
+}
+
+func GetBulkFlushInterval() time.Duration {
+	return getEnvDuration("BULK_FLUSH_INTERVAL", 5*time.Second)
+}
+
+func GetBulkTimeout() time.Duration {
+	return getEnvDuration("BULK_TIMEOUT", 30*time.Second)
+}
+
+// GetBulkQueueSize and GetBulkQueueBytes bound the documents waiting to
+// be indexed; uploads are turned away while either is reached.
+func GetBulkQueueSize() int {
+	return getEnvInt("BULK_QUEUE_SIZE", 10000)
+}
+
+func GetBulkQueueBytes() int {
+	return getEnvInt("BULK_QUEUE_BYTES", 256<<20)
+}
+
+func getEnvInt(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 1 {
+		return def
+	}
+	return value
+}
+
+// getEnvInterval is getEnvDuration for schedules, where "0" turns the
+// schedule off.
+func getEnvInterval(name string, def time.Duration) time.Duration {
+	if os.Getenv(name) == "0" {
+		return 0
+	}
+	return getEnvDuration(name, def)
+}
+
+// getEnvList splits a comma-separated variable, dropping empty items.
+func getEnvList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// GetRefreshPolicy returns the refresh parameter of bulk requests for
+// documents from a source: "true", "false" or "wait_for". It reads
+// REFRESH_POLICY_<SOURCE>, then REFRESH_POLICY. Uploads default to
+// wait_for, so a file is searchable once its job is indexed; other
+// sources default to false.
+func GetRefreshPolicy(source string) string {
+	for _, name := range []string{"REFRESH_POLICY_" + strings.ToUpper(source), "REFRESH_POLICY"} {
+		switch policy := os.Getenv(name); policy {
+		case "true", "false", "wait_for":
+			return policy
+		}
+	}
+	if source == "upload" {
+		return "wait_for"
+	}
+	return "false"
+}
+
+func GetImportStateFile() string {
+	path := os.Getenv("IMPORT_STATE_FILE")
+	if path == "" {
+		return "data/import.json"
+	}
+	return path
+}
+
+func GetAPIKeysFile() string {
+	path := os.Getenv("API_KEYS_FILE")
+	if path == "" {
+		return "data/apikeys.json"
+	}
+	return path
+}
+
+// GetBootstrapAPIKey returns a key accepted with every scope, from
+// API_BOOTSTRAP_KEY, to create the first keys with.
+func GetBootstrapAPIKey() string {
+	return os.Getenv("API_BOOTSTRAP_KEY")
+}
+
+// GetAnonymousScopes returns the scopes of requests made without a key,
+// from the comma-separated API_ANONYMOUS_SCOPES. None by default.
+func GetAnonymousScopes() []string {
+	return getEnvList("API_ANONYMOUS_SCOPES")
+}
+
+func GetWebhooksFile() string {
+	path := os.Getenv("WEBHOOKS_FILE")
+	if path == "" {
+		return "data/webhooks.json"
+	}
+	return path
+}
+
+// GetWatchDirs returns the directories the folder connector indexes,
+// from the comma-separated WATCH_DIRS.
+func GetWatchDirs() []string {
+	if dirs := getEnvList("WATCH_DIRS"); len(dirs) > 0 {
+		return dirs
+	}
+	return []string{"data/documents"}
+}
+
+// GetWatchScanInterval is how often watched directories are scanned in
+// full, to catch changes notifications missed.
+func GetWatchScanInterval() time.Duration {
+	return getEnvDuration("WATCH_SCAN_INTERVAL", time.Hour)
+}
+
+func GetConnectorStateDir() string {
+	dir := os.Getenv("CONNECTOR_STATE_DIR")
+	if dir == "" {
+		return "data/connectors"
+	}
+	return dir
+}
+
+// GetS3Locations returns the "bucket" or "bucket/prefix" locations the
+// S3 connector indexes, from the comma-separated S3_LOCATIONS. The
+// connector is off when there are none.
+func GetS3Locations() []string {
+	return getEnvList("S3_LOCATIONS")
+}
+
+func GetS3Endpoint() string {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		return "https://s3." + GetS3Region() + ".amazonaws.com"
+	}
+	return endpoint
+}
+
+func GetS3Region() string {
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		return "us-east-1"
+	}
+	return region
+}
+
+func GetS3AccessKey() string {
+	return os.Getenv("S3_ACCESS_KEY")
+}
+
+func GetS3SecretKey() string {
+	return os.Getenv("S3_SECRET_KEY")
+}
+
+// GetS3PathStyle reports whether buckets are addressed in the path
+// rather than the host name, as MinIO expects by default.
+func GetS3PathStyle() bool {
+	return os.Getenv("S3_PATH_STYLE") != "false"
+}
+
+// GetS3SyncInterval is how often the S3 connector syncs; zero means
+// only at startup and on demand.
+func GetS3SyncInterval() time.Duration {
+	return getEnvInterval("S3_SYNC_INTERVAL", 15*time.Minute)
+}
+
+// GetCrawlSeeds returns the URLs the crawler starts from, from the
+// comma-separated CRAWL_SEEDS. The crawler is off when there are none.
+func GetCrawlSeeds() []string {
+	return getEnvList("CRAWL_SEEDS")
+}
+
+// GetCrawlAllowedDomains returns the hosts the crawler may visit; a
+// leading dot allows subdomains. None means the hosts of the seeds.
+func GetCrawlAllowedDomains() []string {
+	return getEnvList("CRAWL_ALLOWED_DOMAINS")
+}
+
+// GetCrawlAllowedPaths returns the path prefixes the crawler may visit.
+// None means any path.
+func GetCrawlAllowedPaths() []string {
+	return getEnvList("CRAWL_ALLOWED_PATHS")
+}
+
+func GetCrawlMaxDepth() int {
+	return getEnvInt("CRAWL_MAX_DEPTH", 5)
+}
+
+func GetCrawlMaxPages() int {
+	return getEnvInt("CRAWL_MAX_PAGES", 10000)
+}
+
+// GetCrawlDelay is the least time between two requests to one host.
+func GetCrawlDelay() time.Duration {
+	return getEnvDuration("CRAWL_DELAY", time.Second)
+}
+
+func GetCrawlUserAgent() string {
+	agent := os.Getenv("CRAWL_USER_AGENT")
+	if agent == "" {
+		return "ShallowSeekBot/1.0"
+	}
+	return agent
+}
+
+func GetCrawlInterval() time.Duration {
+	return getEnvInterval("CRAWL_INTERVAL", 24*time.Hour)
+}
+
+// GetIMAPAddr returns the host:port of the IMAP server to index. The
+// IMAP connector is off when it is empty.
+func GetIMAPAddr() string {
+	return os.Getenv("IMAP_ADDR")
+}
+
+// GetIMAPTLS reports whether the IMAP connection uses TLS, as it does
+// unless IMAP_TLS is "false".
+func GetIMAPTLS() bool {
+	return os.Getenv("IMAP_TLS") != "false"
+}
+
+func GetIMAPUsername() string {
+	return os.Getenv("IMAP_USERNAME")
+}
+
+func GetIMAPPassword() string {
+	return os.Getenv("IMAP_PASSWORD")
+}
+
+// GetIMAPFolders returns the mailbox folders to index, from the
+// comma-separated IMAP_FOLDERS, INBOX by default.
+func GetIMAPFolders() []string {
+	if folders := getEnvList("IMAP_FOLDERS"); len(folders) > 0 {
+		return folders
+	}
+	return []string{"INBOX"}
+}
+
+func GetIMAPSyncInterval() time.Duration {
+	return getEnvInterval("IMAP_SYNC_INTERVAL", 15*time.Minute)
+}
+
+// GetWebDAVURL returns the WebDAV collection to index, such as a
+// Nextcloud folder. The WebDAV connector is off when it is empty.
+func GetWebDAVURL() string {
+	return os.Getenv("WEBDAV_URL")
+}
+
+func GetWebDAVUsername() string {
+	return os.Getenv("WEBDAV_USERNAME")
+}
+
+func GetWebDAVPassword() string {
+	return os.Getenv("WEBDAV_PASSWORD")
+}
+
+func GetWebDAVSyncInterval() time.Duration {
+	return getEnvInterval("WEBDAV_SYNC_INTERVAL", time.Hour)
+}
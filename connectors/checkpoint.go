@@ -0,0 +1,152 @@
+package connectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Item is what a checkpoint remembers about one file, object or page:
+// the version that was indexed and the documents it gave. Links are the
+// links found on a web page, so the crawler can go on through a page
+// that hasn't changed without downloading it.
+type Item struct {
+	Version string    `json:"version"`
+	IDs     []string  `json:"ids,omitempty"`
+	Links   []string  `json:"links,omitempty"`
+	Synced  time.Time `json:"synced"`
+}
+
+// checkpoint is the state a connector keeps between runs, so a restart
+// only indexes what changed. State holds connector-specific values.
+type checkpoint struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]Item
+	state map[string]string
+	dirty bool
+}
+
+type checkpointFile struct {
+	Items map[string]Item   `json:"items"`
+	State map[string]string `json:"state,omitempty"`
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{
+		path:  path,
+		items: make(map[string]Item),
+		state: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+
+	var file checkpointFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %v", path, err)
+	}
+	if file.Items != nil {
+		c.items = file.Items
+	}
+	if file.State != nil {
+		c.state = file.State
+	}
+	return c, nil
+}
+
+func (c *checkpoint) item(key string) (Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[key]
+	return item, ok
+}
+
+func (c *checkpoint) set(key string, item Item) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = item
+	c.dirty = true
+}
+
+func (c *checkpoint) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+	c.dirty = true
+}
+
+// keys returns the keys that start with prefix, sorted.
+func (c *checkpoint) keys(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c *checkpoint) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+func (c *checkpoint) getState(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state[name]
+}
+
+func (c *checkpoint) setState(name, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value == "" {
+		delete(c.state, name)
+	} else {
+		c.state[name] = value
+	}
+	c.dirty = true
+}
+
+// save writes the checkpoint if it changed, through a temporary file so
+// a crash never leaves half of one.
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(checkpointFile{Items: c.items, State: c.state})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+	c.dirty = false
+	return nil
+}
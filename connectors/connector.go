@@ -0,0 +1,272 @@
+// Package connectors index documents from places other than uploads:
+// folders, object storage, web sites, mailboxes. Each connector keeps a
+// checkpoint of what it indexed, so it only sends what changed, and
+// gives documents IDs derived from where they were found, so a changed
+// item replaces its previous version in the index.
+package connectors
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shallowseek/batch"
+	"github.com/shallowseek/ingest"
+	"github.com/shallowseek/models"
+)
+
+const (
+	// queueRetryDelay is how long a connector waits when the indexing
+	// queue is full before trying again.
+	queueRetryDelay = 5 * time.Second
+
+	// maxFileSize is the largest file a connector downloads or reads.
+	maxFileSize = 50 << 20
+)
+
+var errStopped = errors.New("connector stopped")
+
+// Index is where connectors send documents and deletions.
+type Index interface {
+	AddDocuments(docs []models.Document) error
+	DeleteDocuments(ids []string) error
+}
+
+// Connector is a running source of documents.
+type Connector interface {
+	Name() string
+	Start()
+	Stop()
+	// Sync asks for a full sync as soon as the connector is free.
+	Sync()
+	Status() Status
+}
+
+// Status describes a connector for the admin API.
+type Status struct {
+	Name      string     `json:"name"`
+	Syncing   bool       `json:"syncing"`
+	LastSync  *time.Time `json:"last_sync,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Items     int        `json:"items"`
+}
+
+// source holds what every connector needs: its name, which is also the
+// Source of its documents, the index and the checkpoint.
+type source struct {
+	name       string
+	index      Index
+	checkpoint *checkpoint
+	stop       chan struct{}
+	trigger    chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+
+	mu        sync.Mutex
+	syncing   bool
+	lastSync  time.Time
+	lastError error
+}
+
+func newSource(name string, index Index, stateDir string) (*source, error) {
+	cp, err := loadCheckpoint(filepath.Join(stateDir, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &source{
+		name:       name,
+		index:      index,
+		checkpoint: cp,
+		stop:       make(chan struct{}),
+		trigger:    make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}, nil
+}
+
+// Stop interrupts the sync in progress, if any, waits for the connector
+// to finish and saves the checkpoint.
+func (s *source) Stop() {
+	close(s.stop)
+	s.cancel()
+	s.wg.Wait()
+	s.save()
+}
+
+// schedule runs sync in the background at start, then every interval
+// unless it is zero, and whenever Sync is called.
+func (s *source) schedule(interval time.Duration, sync func() error) {
+	run := func() {
+		start := time.Now()
+		s.beginSync()
+		err := sync()
+		if s.stopped() {
+			err = nil
+		}
+		s.endSync(err)
+		if err != nil {
+			log.Printf("[Connectors] %s: sync failed after %v: %v", s.name, time.Since(start).Round(time.Millisecond), err)
+		} else {
+			log.Printf("[Connectors] %s: sync finished in %v, %d items tracked", s.name, time.Since(start).Round(time.Millisecond), s.checkpoint.len())
+		}
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		run()
+
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-tick:
+				run()
+			case <-s.trigger:
+				run()
+			}
+		}
+	}()
+}
+
+func (s *source) Name() string {
+	return s.name
+}
+
+func (s *source) Sync() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (s *source) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{Name: s.name, Syncing: s.syncing, Items: s.checkpoint.len()}
+	if !s.lastSync.IsZero() {
+		last := s.lastSync
+		status.LastSync = &last
+	}
+	if s.lastError != nil {
+		status.LastError = s.lastError.Error()
+	}
+	return status
+}
+
+func (s *source) beginSync() {
+	s.mu.Lock()
+	s.syncing = true
+	s.mu.Unlock()
+}
+
+// endSync records the outcome of a full sync and saves the checkpoint.
+func (s *source) endSync(err error) {
+	s.save()
+	s.mu.Lock()
+	s.syncing = false
+	s.lastSync = time.Now()
+	s.lastError = err
+	s.mu.Unlock()
+}
+
+// upToDate reports whether version of the item at key is the one
+// already indexed.
+func (s *source) upToDate(key, version string) bool {
+	item, ok := s.checkpoint.item(key)
+	return ok && item.Version == version
+}
+
+// put indexes the documents of a new version of the item at key and
+// deletes the documents of the previous version that it no longer has.
+// An item whose extraction failed is put with no documents, so it is
+// not tried again until it changes.
+func (s *source) put(key, version string, docs []models.Document) error {
+	ingest.AssignIDs(docs, s.name+":"+key)
+	ids := make([]string, 0, len(docs))
+	current := make(map[string]bool, len(docs))
+	for i := range docs {
+		docs[i].Source = s.name
+		ids = append(ids, docs[i].ID)
+		current[docs[i].ID] = true
+	}
+
+	if len(docs) > 0 {
+		if err := s.add(docs); err != nil {
+			return err
+		}
+	}
+
+	previous, _ := s.checkpoint.item(key)
+	var stale []string
+	for _, id := range previous.IDs {
+		if !current[id] {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) > 0 {
+		if err := s.index.DeleteDocuments(stale); err != nil {
+			return err
+		}
+	}
+
+	s.checkpoint.set(key, Item{Version: version, IDs: ids, Synced: time.Now()})
+	return nil
+}
+
+// remove deletes the documents of an item that is gone.
+func (s *source) remove(key string) error {
+	item, ok := s.checkpoint.item(key)
+	if !ok {
+		return nil
+	}
+	if len(item.IDs) > 0 {
+		if err := s.index.DeleteDocuments(item.IDs); err != nil {
+			return err
+		}
+	}
+	s.checkpoint.remove(key)
+	return nil
+}
+
+// add queues documents, waiting while the queue is full.
+func (s *source) add(docs []models.Document) error {
+	for {
+		err := s.index.AddDocuments(docs)
+		if !errors.Is(err, batch.ErrQueueFull) {
+			return err
+		}
+		log.Printf("[Connectors] %s: indexing queue full, waiting", s.name)
+		select {
+		case <-time.After(queueRetryDelay):
+		case <-s.stop:
+			return errStopped
+		}
+	}
+}
+
+func (s *source) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *source) save() {
+	if err := s.checkpoint.save(); err != nil {
+		log.Printf("[Connectors] %s: error saving checkpoint: %v", s.name, err)
+	}
+}
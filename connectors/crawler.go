@@ -0,0 +1,672 @@
+package connectors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shallowseek/ingest"
+)
+
+const (
+	// maxPageLinks bounds the links kept for each page.
+	maxPageLinks = 1000
+
+	// maxSitemapDepth bounds how deep sitemap indexes are followed.
+	maxSitemapDepth = 2
+)
+
+var (
+	htmlLinkTagPattern = regexp.MustCompile(`(?is)<(a|link|base|meta)\s[^>]*>`)
+	htmlAttrPattern    = regexp.MustCompile(`(?is)([a-z][a-z0-9_:-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// contentTypeExts gives the extractor type of documents whose URL has
+// no usable extension.
+var contentTypeExts = map[string]string{
+	"text/html":             ".html",
+	"application/xhtml+xml": ".html",
+	"text/plain":            ".txt",
+	"text/markdown":         ".md",
+	"text/csv":              ".csv",
+	"application/pdf":       ".pdf",
+	"application/msword":    ".doc",
+	"application/rtf":       ".rtf",
+	"application/epub+zip":  ".epub",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   ".docx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         ".xlsx",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": ".pptx",
+	"application/vnd.oasis.opendocument.text":                                   ".odt",
+	"application/vnd.oasis.opendocument.spreadsheet":                            ".ods",
+}
+
+// CrawlerConfig says what the crawler crawls. Allowed domains match the
+// host or, when they start with a dot, its subdomains; they default to
+// the hosts of the seeds. Allowed paths are path prefixes; none means
+// any path.
+type CrawlerConfig struct {
+	Seeds          []string
+	AllowedDomains []string
+	AllowedPaths   []string
+	MaxDepth       int
+	MaxPages       int
+	Delay          time.Duration
+	UserAgent      string
+	Interval       time.Duration
+}
+
+// Crawler indexes web pages, and the documents they link to, starting
+// from seed URLs and the sitemaps of their sites. It keeps to the
+// allowed domains and paths, obeys robots.txt and robots meta tags,
+// waits between requests to the same host and indexes each page once
+// under its canonical URL, which is the document's Path. Pages are
+// fetched again with If-None-Match and If-Modified-Since, and pages no
+// longer reached are deleted once a crawl completes.
+type Crawler struct {
+	*source
+	cfg    CrawlerConfig
+	seeds  []string
+	client *http.Client
+
+	robots     map[string]*robots
+	lastFetch  map[string]time.Time
+	incomplete bool
+}
+
+type crawlTask struct {
+	url   string
+	depth int
+}
+
+// pageVersion is what a checkpoint keeps to fetch a page conditionally
+// and to tell whether its content changed.
+type pageVersion struct {
+	etag         string
+	lastModified string
+	hash         string
+}
+
+func (v pageVersion) String() string {
+	return v.etag + "\n" + v.lastModified + "\n" + v.hash
+}
+
+func parsePageVersion(s string) pageVersion {
+	parts := strings.SplitN(s, "\n", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return pageVersion{etag: parts[0], lastModified: parts[1], hash: parts[2]}
+}
+
+func NewCrawler(cfg CrawlerConfig, index Index, stateDir string) (*Crawler, error) {
+	c := &Crawler{cfg: cfg}
+	for _, seed := range cfg.Seeds {
+		u, ok := canonicalURL(seed, nil)
+		if !ok {
+			return nil, fmt.Errorf("invalid seed URL: %q", seed)
+		}
+		c.seeds = append(c.seeds, u)
+		if len(cfg.AllowedDomains) == 0 {
+			parsed, _ := url.Parse(u)
+			c.cfg.AllowedDomains = append(c.cfg.AllowedDomains, parsed.Hostname())
+		}
+	}
+
+	c.client = &http.Client{
+		Timeout: time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("too many redirects")
+			}
+			if !c.inScope(req.URL.String()) {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+
+	src, err := newSource("crawler", index, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	c.source = src
+	return c, nil
+}
+
+func (c *Crawler) Start() {
+	c.schedule(c.cfg.Interval, c.crawl)
+}
+
+func (c *Crawler) crawl() error {
+	c.robots = make(map[string]*robots)
+	c.lastFetch = make(map[string]time.Time)
+	c.incomplete = false
+
+	queued := make(map[string]bool)
+	seen := make(map[string]bool)
+	var queue []crawlTask
+	enqueue := func(u string, depth int) {
+		if depth > c.cfg.MaxDepth || queued[u] || !c.inScope(u) {
+			return
+		}
+		queued[u] = true
+		queue = append(queue, crawlTask{url: u, depth: depth})
+	}
+
+	for _, seed := range c.seeds {
+		enqueue(seed, 0)
+	}
+	for _, u := range c.sitemapURLs() {
+		enqueue(u, 0)
+	}
+
+	pages := 0
+	for len(queue) > 0 {
+		if c.stopped() {
+			return nil
+		}
+		if pages >= c.cfg.MaxPages {
+			log.Printf("[Crawler] Page limit of %d reached, %d URLs left", c.cfg.MaxPages, len(queue))
+			c.incomplete = true
+			break
+		}
+		task := queue[0]
+		queue = queue[1:]
+		pages++
+
+		key, links, err := c.visit(task.url)
+		if err != nil {
+			if c.stopped() {
+				return nil
+			}
+			log.Printf("[Crawler] Error crawling %s: %v", task.url, err)
+			// Keep what was indexed from it; it may be back next time.
+			seen[c.pageKey(task.url)] = true
+			continue
+		}
+		c.setPageKey(task.url, key)
+		if key != "" {
+			seen[key] = true
+		}
+		for _, link := range links {
+			enqueue(link, task.depth+1)
+		}
+	}
+	c.save()
+
+	if c.incomplete {
+		return nil
+	}
+	for _, key := range c.checkpoint.keys("") {
+		if seen[key] {
+			continue
+		}
+		if err := c.remove(key); err != nil {
+			return err
+		}
+		log.Printf("[Crawler] Removed %s: no longer reachable", key)
+	}
+	return nil
+}
+
+// visit fetches a page and indexes it if it changed. It returns the key
+// the page is indexed under, if any, and the links to follow from it.
+func (c *Crawler) visit(rawURL string) (string, []string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+	rules := c.robotsFor(u)
+	if !rules.allowed(u.RequestURI()) {
+		return "", nil, nil
+	}
+
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	previousKey := c.pageKey(rawURL)
+	previous, known := c.checkpoint.item(previousKey)
+	if known {
+		v := parsePageVersion(previous.Version)
+		if v.etag != "" {
+			req.Header.Set("If-None-Match", v.etag)
+		}
+		if v.lastModified != "" {
+			req.Header.Set("If-Modified-Since", v.lastModified)
+		}
+	}
+
+	if err := c.wait(u.Host, rules.delay); err != nil {
+		return "", nil, err
+	}
+	res, err := c.client.Do(req)
+	c.lastFetch[u.Host] = time.Now()
+	if err != nil {
+		return "", nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && known:
+		return previousKey, previous.Links, nil
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return "", nil, nil
+	case res.StatusCode >= 300 && res.StatusCode < 400:
+		// A redirect out of scope.
+		return "", nil, nil
+	case res.StatusCode != http.StatusOK:
+		return "", nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	final, ok := canonicalURL(res.Request.URL.String(), nil)
+	if !ok || !c.inScope(final) {
+		return "", nil, nil
+	}
+	ext := documentType(final, res.Header.Get("Content-Type"))
+	if ext == "" {
+		return "", nil, nil
+	}
+	content, err := io.ReadAll(io.LimitReader(res.Body, maxFileSize))
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := final
+	var links []string
+	index := true
+	if ext == ".html" || ext == ".htm" {
+		page := parseLinks(res.Request.URL, content)
+		if page.canonical != "" && c.inScope(page.canonical) {
+			key = page.canonical
+		}
+		if !page.nofollow {
+			links = page.links
+		}
+		index = !page.noindex
+	}
+	if len(links) > maxPageLinks {
+		links = links[:maxPageLinks]
+	}
+
+	hash := sha256.Sum256(content)
+	version := pageVersion{
+		etag:         res.Header.Get("ETag"),
+		lastModified: res.Header.Get("Last-Modified"),
+		hash:         hex.EncodeToString(hash[:]),
+	}
+	if !index {
+		if err := c.remove(key); err != nil {
+			return "", nil, err
+		}
+		return "", links, nil
+	}
+
+	if current, ok := c.checkpoint.item(key); ok && parsePageVersion(current.Version).hash == version.hash {
+		// Same content served again; remember the new validators only.
+		current.Version = version.String()
+		current.Links = links
+		current.Synced = time.Now()
+		c.checkpoint.set(key, current)
+		return key, links, nil
+	}
+
+	docs, err := ingest.DocumentsOfType(key, ext, content)
+	if err != nil {
+		log.Printf("[Crawler] Error extracting text from %s: %v", key, err)
+		docs = nil
+	}
+	if err := c.put(key, version.String(), docs); err != nil {
+		return "", nil, err
+	}
+	item, _ := c.checkpoint.item(key)
+	item.Links = links
+	c.checkpoint.set(key, item)
+	if docs != nil {
+		log.Printf("[Crawler] Indexed %s", key)
+	}
+	return key, links, nil
+}
+
+// pageKey returns the key the page at a URL was last indexed under:
+// the canonical URL it named, or the URL itself.
+func (c *Crawler) pageKey(rawURL string) string {
+	if key := c.checkpoint.getState("canonical:" + rawURL); key != "" {
+		return key
+	}
+	return rawURL
+}
+
+// setPageKey remembers the key the page at a URL was indexed under, so
+// it is fetched conditionally and kept on errors under that key.
+func (c *Crawler) setPageKey(rawURL, key string) {
+	if key == rawURL {
+		key = ""
+	}
+	if c.checkpoint.getState("canonical:"+rawURL) != key {
+		c.checkpoint.setState("canonical:"+rawURL, key)
+	}
+}
+
+// robotsFor returns the robots.txt rules of a URL's host, fetching them
+// once per crawl. If they can't be fetched the host is skipped, and the
+// crawl doesn't count as complete so nothing is deleted.
+func (c *Crawler) robotsFor(u *url.URL) *robots {
+	origin := u.Scheme + "://" + u.Host
+	if rules, ok := c.robots[origin]; ok {
+		return rules
+	}
+
+	rules, err := c.fetchRobots(origin)
+	if err != nil {
+		log.Printf("[Crawler] Error fetching %s/robots.txt, skipping host: %v", origin, err)
+		rules = &robots{rules: []robotsRule{{allow: false, length: 1, pattern: robotsPattern("/")}}}
+		c.incomplete = true
+	}
+	c.robots[origin] = rules
+	return rules
+}
+
+func (c *Crawler) fetchRobots(origin string) (*robots, error) {
+	host := strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://")
+	if err := c.wait(host, c.cfg.Delay); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	res, err := c.client.Do(req)
+	c.lastFetch[host] = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return parseRobots(res.Body, agentToken(c.cfg.UserAgent)), nil
+	case res.StatusCode >= 400 && res.StatusCode < 500:
+		return &robots{}, nil
+	default:
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+}
+
+// sitemapURLs returns the pages listed in the sitemaps of the seeds'
+// sites: those named in robots.txt, or /sitemap.xml.
+func (c *Crawler) sitemapURLs() []string {
+	var pages []string
+	done := make(map[string]bool)
+	for _, seed := range c.seeds {
+		u, _ := url.Parse(seed)
+		origin := u.Scheme + "://" + u.Host
+		if done[origin] {
+			continue
+		}
+		done[origin] = true
+
+		sitemaps := c.robotsFor(u).sitemaps
+		if len(sitemaps) == 0 {
+			sitemaps = []string{origin + "/sitemap.xml"}
+		}
+		for _, sitemap := range sitemaps {
+			pages = append(pages, c.readSitemap(sitemap, 0)...)
+			if len(pages) >= c.cfg.MaxPages {
+				return pages
+			}
+		}
+	}
+	return pages
+}
+
+func (c *Crawler) readSitemap(sitemapURL string, depth int) []string {
+	u, err := url.Parse(sitemapURL)
+	if err != nil || c.stopped() {
+		return nil
+	}
+	if err := c.wait(u.Host, c.cfg.Delay); err != nil {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", c.cfg.UserAgent)
+	res, err := c.client.Do(req)
+	c.lastFetch[u.Host] = time.Now()
+	if err != nil {
+		log.Printf("[Crawler] Error fetching sitemap %s: %v", sitemapURL, err)
+		return nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxFileSize))
+	if err != nil {
+		return nil
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil
+		}
+		data, err = io.ReadAll(io.LimitReader(zr, maxFileSize))
+		if err != nil {
+			return nil
+		}
+	}
+
+	var sitemap struct {
+		URLs []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	if err := xml.Unmarshal(data, &sitemap); err != nil {
+		log.Printf("[Crawler] Error parsing sitemap %s: %v", sitemapURL, err)
+		return nil
+	}
+
+	var pages []string
+	for _, entry := range sitemap.URLs {
+		if page, ok := canonicalURL(strings.TrimSpace(entry.Loc), nil); ok {
+			pages = append(pages, page)
+		}
+	}
+	if depth < maxSitemapDepth {
+		for _, entry := range sitemap.Sitemaps {
+			pages = append(pages, c.readSitemap(strings.TrimSpace(entry.Loc), depth+1)...)
+		}
+	}
+	return pages
+}
+
+// wait sleeps until the host may be sent another request.
+func (c *Crawler) wait(host string, delay time.Duration) error {
+	if delay < c.cfg.Delay {
+		delay = c.cfg.Delay
+	}
+	next := c.lastFetch[host].Add(delay)
+	if d := time.Until(next); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-c.stop:
+			return errStopped
+		}
+	}
+	return nil
+}
+
+func (c *Crawler) inScope(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	domainOK := false
+	for _, domain := range c.cfg.AllowedDomains {
+		domain = strings.ToLower(domain)
+		if host == strings.TrimPrefix(domain, ".") || (strings.HasPrefix(domain, ".") && strings.HasSuffix(host, domain)) {
+			domainOK = true
+			break
+		}
+	}
+	if !domainOK {
+		return false
+	}
+
+	if len(c.cfg.AllowedPaths) == 0 {
+		return true
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	for _, prefix := range c.cfg.AllowedPaths {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalURL resolves ref against base, if given, and normalises it so
+// the same page is found under one URL: lower-case scheme and host, no
+// default port, no fragment and "/" for an empty path.
+func canonicalURL(ref string, base *url.URL) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), true
+}
+
+// htmlLinks is what the crawler needs from a page besides its text.
+type htmlLinks struct {
+	links     []string
+	canonical string
+	noindex   bool
+	nofollow  bool
+}
+
+// parseLinks finds the links of an HTML page, its canonical URL and its
+// robots meta tag. Links marked rel="nofollow" are left out.
+func parseLinks(pageURL *url.URL, content []byte) htmlLinks {
+	var result htmlLinks
+	base := pageURL
+	seen := make(map[string]bool)
+
+	for _, tag := range htmlLinkTagPattern.FindAllSubmatch(content, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range htmlAttrPattern.FindAllSubmatch(tag[0], -1) {
+			value := string(attr[2]) + string(attr[3]) + string(attr[4])
+			attrs[strings.ToLower(string(attr[1]))] = html.UnescapeString(value)
+		}
+		rel := strings.Fields(strings.ToLower(attrs["rel"]))
+
+		switch strings.ToLower(string(tag[1])) {
+		case "base":
+			if href, err := url.Parse(attrs["href"]); err == nil && attrs["href"] != "" {
+				base = pageURL.ResolveReference(href)
+			}
+		case "meta":
+			if strings.ToLower(attrs["name"]) == "robots" {
+				directives := strings.ToLower(attrs["content"])
+				result.noindex = result.noindex || strings.Contains(directives, "noindex") || strings.Contains(directives, "none")
+				result.nofollow = result.nofollow || strings.Contains(directives, "nofollow") || strings.Contains(directives, "none")
+			}
+		case "link":
+			if contains(rel, "canonical") {
+				if u, ok := canonicalURL(attrs["href"], base); ok {
+					result.canonical = u
+				}
+			}
+		case "a":
+			if contains(rel, "nofollow") || attrs["href"] == "" {
+				continue
+			}
+			if u, ok := canonicalURL(attrs["href"], base); ok && !seen[u] {
+				seen[u] = true
+				result.links = append(result.links, u)
+			}
+		}
+	}
+	return result
+}
+
+// documentType returns the extractor type of a fetched document, from
+// its Content-Type or the extension of its URL, or "" if it can't be
+// indexed.
+func documentType(rawURL, contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if ext := contentTypeExts[mediaType]; ext == ".html" {
+		return ext
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		if ext := strings.ToLower(path.Ext(u.Path)); ingest.IsSupported(ext) {
+			return ext
+		}
+	}
+	return contentTypeExts[mediaType]
+}
+
+// agentToken is the product token of a User-Agent, which robots.txt
+// groups are matched against.
+func agentToken(userAgent string) string {
+	token, _, _ := strings.Cut(userAgent, "/")
+	return strings.TrimSpace(token)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package connectors
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeSite serves one page that names another URL as its canonical one.
+type fakeSite struct {
+	mu          sync.Mutex
+	fail        bool
+	conditional []string
+}
+
+func (s *fakeSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.URL.Path != "/page" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Header.Get("If-None-Match") == `"v1"` {
+		s.conditional = append(s.conditional, r.URL.Path)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<html><head><title>Отчёт</title><link rel="canonical" href="http://%s/reports/q1"></head>`+
+		`<body><p>Квартальный отчёт</p></body></html>`, r.Host)
+}
+
+func TestCrawlerCanonicalPage(t *testing.T) {
+	site := &fakeSite{}
+	server := httptest.NewServer(site)
+	defer server.Close()
+
+	index := newFakeIndex()
+	crawler, err := NewCrawler(CrawlerConfig{
+		Seeds:     []string{server.URL + "/page"},
+		MaxDepth:  1,
+		MaxPages:  10,
+		UserAgent: "test",
+	}, index, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical := server.URL + "/reports/q1"
+
+	if err := crawler.crawl(); err != nil {
+		t.Fatalf("first crawl: %v", err)
+	}
+	assertStrings(t, "indexed after first crawl", index.paths(), []string{canonical})
+
+	// The page is fetched again with the validators it was indexed with.
+	if err := crawler.crawl(); err != nil {
+		t.Fatalf("second crawl: %v", err)
+	}
+	if len(site.conditional) != 1 {
+		t.Errorf("page fetched conditionally %d times, want 1", len(site.conditional))
+	}
+	assertStrings(t, "indexed after unchanged crawl", index.paths(), []string{canonical})
+
+	// A failed fetch keeps the page it was indexed as.
+	site.mu.Lock()
+	site.fail = true
+	site.mu.Unlock()
+	if err := crawler.crawl(); err != nil {
+		t.Fatalf("failing crawl: %v", err)
+	}
+	assertStrings(t, "indexed after failed fetch", index.paths(), []string{canonical})
+	if _, ok := crawler.checkpoint.item(canonical); !ok {
+		t.Errorf("canonical page dropped from the checkpoint after a failed fetch")
+	}
+}
@@ -0,0 +1,244 @@
+package connectors
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shallowseek/ingest"
+)
+
+// settleDelay is how long the folder connector waits after a change
+// before indexing, so a file being written is read once it's done.
+const settleDelay = 2 * time.Second
+
+// Folder indexes the supported files under a set of directories. It
+// scans them at start and every interval, and in between follows
+// changes through inotify where available. Files removed from the
+// directories are deleted from the index. Hidden files and directories
+// are skipped.
+type Folder struct {
+	*source
+	dirs     []string
+	interval time.Duration
+}
+
+func NewFolder(dirs []string, index Index, stateDir string, interval time.Duration) (*Folder, error) {
+	src, err := newSource("folder", index, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	cleaned := make([]string, len(dirs))
+	for i, dir := range dirs {
+		cleaned[i] = filepath.Clean(dir)
+	}
+	return &Folder{source: src, dirs: cleaned, interval: interval}, nil
+}
+
+func (f *Folder) Start() {
+	f.wg.Add(1)
+	go f.run()
+}
+
+func (f *Folder) run() {
+	defer f.wg.Done()
+
+	w, err := newWatcher()
+	if err != nil {
+		log.Printf("[Folder] Change notifications unavailable, relying on scans every %v: %v", f.interval, err)
+	}
+	var events <-chan string
+	if w != nil {
+		defer w.close()
+		events = w.events
+	}
+
+	log.Printf("[Folder] Watching %s", strings.Join(f.dirs, ", "))
+	f.scan(w)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	pending := make(map[string]bool)
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.scan(w)
+		case <-f.trigger:
+			f.scan(w)
+		case path := <-events:
+			pending[path] = true
+			if settled == nil {
+				settled = time.After(settleDelay)
+			}
+		case <-settled:
+			settled = nil
+			if pending[""] {
+				// The kernel dropped events; only a scan can catch up.
+				log.Printf("[Folder] Change notifications overflowed, rescanning")
+				f.scan(w)
+			} else {
+				for path := range pending {
+					if f.stopped() {
+						return
+					}
+					f.sync(w, path)
+				}
+				f.save()
+			}
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+// scan syncs every watched directory.
+func (f *Folder) scan(w *watcher) {
+	start := time.Now()
+	f.beginSync()
+	var missing error
+	for _, dir := range f.dirs {
+		if f.stopped() {
+			break
+		}
+		if !f.sync(w, dir) {
+			missing = fmt.Errorf("watched directory %s could not be read", dir)
+		}
+	}
+	f.endSync(missing)
+	log.Printf("[Folder] Scan finished in %v, %d files tracked", time.Since(start).Round(time.Millisecond), f.checkpoint.len())
+}
+
+// sync brings the index up to date with path, a file or a directory:
+// new and changed files are indexed and files no longer there deleted.
+// Files under a directory that can't be read are left alone, so a
+// missing mount doesn't empty the index. It reports whether path could
+// be read.
+func (f *Folder) sync(w *watcher, path string) bool {
+	seen := make(map[string]bool)
+	var unreadable []string
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == path && os.IsNotExist(err) {
+				return err
+			}
+			log.Printf("[Folder] Error reading %s: %v", p, err)
+			unreadable = append(unreadable, p)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !f.isRoot(p) && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if w != nil {
+				if err := w.add(p); err != nil {
+					log.Printf("[Folder] Error watching %s: %v", p, err)
+				}
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !ingest.IsSupported(filepath.Ext(p)) {
+			return nil
+		}
+
+		seen[p] = true
+		if err := f.syncFile(p, d); err != nil {
+			if err == errStopped {
+				return err
+			}
+			log.Printf("[Folder] Error indexing %s: %v", p, err)
+			unreadable = append(unreadable, p)
+		}
+		return nil
+	})
+	if err == errStopped {
+		return true
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("[Folder] Error scanning %s: %v", path, err)
+		return false
+	}
+	if err != nil && f.isRoot(path) {
+		log.Printf("[Folder] Watched directory %s does not exist", path)
+		return false
+	}
+
+	for _, key := range f.checkpoint.keys(path) {
+		if key != path && !strings.HasPrefix(key, path+string(filepath.Separator)) {
+			continue
+		}
+		if seen[key] || under(key, unreadable) {
+			continue
+		}
+		if err := f.remove(key); err != nil {
+			log.Printf("[Folder] Error deleting documents of %s: %v", key, err)
+			continue
+		}
+		log.Printf("[Folder] Removed %s", key)
+	}
+	return true
+}
+
+func (f *Folder) syncFile(path string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	if info.Size() > maxFileSize {
+		log.Printf("[Folder] Skipping %s: too large (%d bytes)", path, info.Size())
+		return nil
+	}
+
+	version := fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())
+	if f.upToDate(path, version) {
+		return nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// Files a chat export refers to are next to it.
+	docs, err := ingest.DocumentsInDir(path, content, os.DirFS(filepath.Dir(path)))
+	if err != nil {
+		log.Printf("[Folder] Error extracting text from %s: %v", path, err)
+		return f.put(path, version, nil)
+	}
+	if err := f.put(path, version, docs); err != nil {
+		return err
+	}
+	log.Printf("[Folder] Indexed %s (%d documents)", path, len(docs))
+	return nil
+}
+
+func (f *Folder) isRoot(path string) bool {
+	for _, dir := range f.dirs {
+		if dir == path {
+			return true
+		}
+	}
+	return false
+}
+
+// under reports whether path is one of paths or inside one of them.
+func under(path string, paths []string) bool {
+	for _, p := range paths {
+		if path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
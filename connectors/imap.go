@@ -0,0 +1,184 @@
+package connectors
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shallowseek/ingest"
+)
+
+// IMAPConfig says which mailboxes the IMAP connector indexes.
+type IMAPConfig struct {
+	Addr     string
+	TLS      bool
+	Username string
+	Password string
+	Folders  []string
+	Interval time.Duration
+}
+
+// IMAP indexes the messages of mailbox folders, with their attachments,
+// as email documents with Path "folder/uid.eml". It opens folders
+// read-only and remembers each folder's UIDVALIDITY and the UIDs it
+// indexed, so a sync fetches only new messages and deletes the
+// documents of messages no longer on the server. When the server resets
+// a folder's UIDVALIDITY, the folder is indexed again from scratch.
+type IMAP struct {
+	*source
+	cfg IMAPConfig
+}
+
+func NewIMAP(cfg IMAPConfig, index Index, stateDir string) (*IMAP, error) {
+	if len(cfg.Folders) == 0 {
+		cfg.Folders = []string{"INBOX"}
+	}
+	src, err := newSource("imap", index, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	return &IMAP{source: src, cfg: cfg}, nil
+}
+
+func (c *IMAP) Start() {
+	c.schedule(c.cfg.Interval, c.syncAll)
+}
+
+func (c *IMAP) syncAll() error {
+	client, err := dialIMAP(c.ctx, c.cfg.Addr, c.cfg.TLS)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", c.cfg.Addr, err)
+	}
+	defer client.logout()
+	if err := client.login(c.cfg.Username, c.cfg.Password); err != nil {
+		return err
+	}
+
+	var failed error
+	for _, folder := range c.cfg.Folders {
+		if c.stopped() {
+			return nil
+		}
+		if err := c.syncFolder(client, folder); err != nil {
+			log.Printf("[IMAP] Error syncing %s: %v", folder, err)
+			failed = err
+		}
+	}
+	if failed != nil || c.stopped() {
+		return failed
+	}
+
+	// Messages of folders dropped from the configuration go too.
+	for _, key := range c.checkpoint.keys("") {
+		if !c.configured(key) {
+			if err := c.remove(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *IMAP) syncFolder(client *imapClient, folder string) error {
+	validity, err := client.examine(folder)
+	if err != nil {
+		return err
+	}
+	prefix := folder + "/"
+	stateName := "uidvalidity:" + folder
+	if previous := c.checkpoint.getState(stateName); previous != "" && previous != validity {
+		log.Printf("[IMAP] UIDVALIDITY of %s changed from %s to %s, indexing it again", folder, previous, validity)
+		for _, key := range c.checkpoint.keys(prefix) {
+			if err := c.remove(key); err != nil {
+				return err
+			}
+		}
+	}
+	c.checkpoint.setState(stateName, validity)
+
+	uids, err := client.uids()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool, len(uids))
+	added := 0
+	for _, uid := range uids {
+		if c.stopped() {
+			return nil
+		}
+		key := prefix + strconv.FormatUint(uint64(uid), 10)
+		current[key] = true
+		if c.upToDate(key, validity) {
+			continue
+		}
+
+		raw, err := client.fetch(uid)
+		switch err {
+		case nil:
+		case errIMAPNoMessage:
+			// Expunged since the search.
+			delete(current, key)
+			continue
+		case errIMAPTooLarge:
+			log.Printf("[IMAP] Skipping message %s: too large", key)
+			if err := c.put(key, validity, nil); err != nil {
+				return err
+			}
+			continue
+		default:
+			return err
+		}
+
+		if err := c.putMessage(key, validity, raw); err != nil {
+			return err
+		}
+		added++
+		if added%100 == 0 {
+			c.save()
+		}
+	}
+
+	removed := 0
+	for _, key := range c.checkpoint.keys(prefix) {
+		if current[key] || strings.Contains(strings.TrimPrefix(key, prefix), "/") {
+			continue
+		}
+		if err := c.remove(key); err != nil {
+			return err
+		}
+		removed++
+	}
+	c.save()
+	if added > 0 || removed > 0 {
+		log.Printf("[IMAP] %s: indexed %d new messages, removed %d", folder, added, removed)
+	}
+	return nil
+}
+
+func (c *IMAP) putMessage(key, validity string, raw []byte) error {
+	docs, err := ingest.DocumentsOfType(key+".eml", ".eml", raw)
+	if err != nil {
+		log.Printf("[IMAP] Error extracting message %s: %v", key, err)
+		return c.put(key, validity, nil)
+	}
+	return c.put(key, validity, docs)
+}
+
+// configured reports whether a key belongs to a configured folder. Keys
+// are "folder/uid", and folder names may themselves contain slashes.
+func (c *IMAP) configured(key string) bool {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return false
+	}
+	folder := key[:i]
+	for _, f := range c.cfg.Folders {
+		if f == folder {
+			return true
+		}
+	}
+	return false
+}
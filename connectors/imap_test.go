@@ -0,0 +1,203 @@
+package connectors
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeIMAP is an in-process IMAP server with one mailbox, answering the
+// commands the connector sends.
+type fakeIMAP struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	validity int
+	messages map[uint32]string
+	fetched  []uint32
+}
+
+func newFakeIMAP(t *testing.T) *fakeIMAP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeIMAP{listener: listener, validity: 1, messages: map[uint32]string{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeIMAP) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeIMAP) add(uid uint32, subject string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[uid] = "From: sender@example.com\r\nTo: user@example.com\r\nSubject: " + subject +
+		"\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nBody of " + subject + "\r\n"
+}
+
+func (s *fakeIMAP) expunge(uid uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.messages, uid)
+}
+
+// reset gives the mailbox a new UIDVALIDITY, as a server does when it
+// renumbers its messages.
+func (s *fakeIMAP) reset(validity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.validity = validity
+}
+
+// takeFetched returns the UIDs fetched since the last call.
+func (s *fakeIMAP) takeFetched() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fetched := s.fetched
+	s.fetched = nil
+	sort.Slice(fetched, func(i, j int) bool { return fetched[i] < fetched[j] })
+	return fetched
+}
+
+func (s *fakeIMAP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake IMAP ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, cmd, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		upper := strings.ToUpper(cmd)
+
+		s.mu.Lock()
+		switch {
+		case strings.HasPrefix(upper, "LOGIN "):
+			if cmd != `LOGIN "user" "secret"` {
+				fmt.Fprintf(conn, "%s NO [AUTHENTICATIONFAILED] invalid credentials\r\n", tag)
+				break
+			}
+			fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+		case upper == `EXAMINE "INBOX"`:
+			fmt.Fprintf(conn, "* %d EXISTS\r\n* OK [UIDVALIDITY %d] UIDs valid\r\n%s OK [READ-ONLY] EXAMINE completed\r\n",
+				len(s.messages), s.validity, tag)
+		case strings.HasPrefix(upper, "EXAMINE "):
+			fmt.Fprintf(conn, "%s NO no such mailbox\r\n", tag)
+		case upper == "UID SEARCH ALL":
+			var uids []string
+			for uid := range s.messages {
+				uids = append(uids, strconv.FormatUint(uint64(uid), 10))
+			}
+			sort.Strings(uids)
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK SEARCH completed\r\n", strings.Join(uids, " "), tag)
+		case strings.HasPrefix(upper, "UID FETCH "):
+			fields := strings.Fields(cmd)
+			uid, _ := strconv.ParseUint(fields[2], 10, 32)
+			if msg, ok := s.messages[uint32(uid)]; ok {
+				s.fetched = append(s.fetched, uint32(uid))
+				fmt.Fprintf(conn, "* 1 FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid, len(msg), msg)
+			}
+			fmt.Fprintf(conn, "%s OK FETCH completed\r\n", tag)
+		case upper == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE logging out\r\n%s OK LOGOUT completed\r\n", tag)
+			s.mu.Unlock()
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestIMAPSync(t *testing.T) {
+	server := newFakeIMAP(t)
+	index := newFakeIndex()
+	conn, err := NewIMAP(IMAPConfig{
+		Addr:     server.addr(),
+		Username: "user",
+		Password: "secret",
+		Folders:  []string{"INBOX"},
+	}, index, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.add(1, "First")
+	server.add(2, "Second")
+	if err := conn.syncAll(); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	assertUIDs(t, "fetched in first sync", server.takeFetched(), []uint32{1, 2})
+	assertStrings(t, "indexed after first sync", index.paths(), []string{"INBOX/1.eml", "INBOX/2.eml"})
+
+	// Only new messages are fetched, and expunged ones are removed.
+	server.add(3, "Third")
+	server.expunge(1)
+	if err := conn.syncAll(); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	assertUIDs(t, "fetched in second sync", server.takeFetched(), []uint32{3})
+	assertStrings(t, "indexed after second sync", index.paths(), []string{"INBOX/2.eml", "INBOX/3.eml"})
+	if _, ok := conn.checkpoint.item("INBOX/1"); ok {
+		t.Errorf("expunged message still in the checkpoint")
+	}
+
+	// A new UIDVALIDITY means the UIDs may now name other messages, so
+	// the folder is indexed again.
+	server.reset(2)
+	if err := conn.syncAll(); err != nil {
+		t.Fatalf("sync after UIDVALIDITY change: %v", err)
+	}
+	assertUIDs(t, "fetched after UIDVALIDITY change", server.takeFetched(), []uint32{2, 3})
+	assertStrings(t, "indexed after UIDVALIDITY change", index.paths(), []string{"INBOX/2.eml", "INBOX/3.eml"})
+	if got := conn.checkpoint.getState("uidvalidity:INBOX"); got != "2" {
+		t.Errorf("remembered UIDVALIDITY = %q, want 2", got)
+	}
+
+	// Nothing changed: nothing is fetched.
+	if err := conn.syncAll(); err != nil {
+		t.Fatalf("unchanged sync: %v", err)
+	}
+	assertUIDs(t, "fetched in unchanged sync", server.takeFetched(), nil)
+}
+
+func TestIMAPSyncLoginFailure(t *testing.T) {
+	server := newFakeIMAP(t)
+	conn, err := NewIMAP(IMAPConfig{
+		Addr:     server.addr(),
+		Username: "user",
+		Password: "wrong",
+	}, newFakeIndex(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.syncAll(); err == nil || !strings.Contains(err.Error(), "LOGIN failed") {
+		t.Errorf("syncAll with a wrong password = %v, want a LOGIN error", err)
+	}
+}
+
+func assertUIDs(t *testing.T, what string, got, want []uint32) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
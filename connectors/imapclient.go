@@ -0,0 +1,222 @@
+package connectors
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const imapTimeout = 5 * time.Minute
+
+var (
+	errIMAPNoMessage = errors.New("message not found")
+	errIMAPTooLarge  = errors.New("message too large")
+)
+
+var (
+	imapLiteralPattern     = regexp.MustCompile(`\{(\d+)\+?\}$`)
+	imapUIDValidityPattern = regexp.MustCompile(`(?i)\[UIDVALIDITY (\d+)\]`)
+	imapFetchUIDPattern    = regexp.MustCompile(`(?i)\bUID (\d+)`)
+)
+
+// imapClient speaks the little of IMAP4rev1 the connector needs: log in,
+// open a mailbox read-only, list its UIDs and fetch messages by UID.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	done chan struct{}
+}
+
+// imapLine is a response line with the literals it carried, which are
+// left out of its text.
+type imapLine struct {
+	text     string
+	literals [][]byte
+}
+
+func dialIMAP(ctx context.Context, addr string, useTLS bool) (*imapClient, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn), done: make(chan struct{})}
+	// Closing the connection interrupts a command waiting on the server.
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-c.done:
+		}
+	}()
+
+	conn.SetDeadline(time.Now().Add(imapTimeout))
+	greeting, err := c.readLine()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	if !strings.HasPrefix(strings.ToUpper(greeting.text), "* OK") && !strings.HasPrefix(strings.ToUpper(greeting.text), "* PREAUTH") {
+		c.close()
+		return nil, fmt.Errorf("unexpected IMAP greeting: %s", greeting.text)
+	}
+	return c, nil
+}
+
+func (c *imapClient) login(username, password string) error {
+	_, err := c.command("LOGIN " + imapQuote(username) + " " + imapQuote(password))
+	return err
+}
+
+// examine opens a mailbox read-only and returns its UIDVALIDITY.
+func (c *imapClient) examine(mailbox string) (string, error) {
+	lines, err := c.command("EXAMINE " + imapQuote(mailbox))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range lines {
+		if m := imapUIDValidityPattern.FindStringSubmatch(line.text); m != nil {
+			return m[1], nil
+		}
+	}
+	return "", fmt.Errorf("no UIDVALIDITY for mailbox %s", mailbox)
+}
+
+// uids returns the UIDs of the messages in the open mailbox.
+func (c *imapClient) uids() ([]uint32, error) {
+	lines, err := c.command("UID SEARCH ALL")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, line := range lines {
+		fields := strings.Fields(line.text)
+		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, field := range fields[2:] {
+			if uid, err := strconv.ParseUint(field, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// fetch returns the raw message with a UID, without marking it seen.
+func (c *imapClient) fetch(uid uint32) ([]byte, error) {
+	lines, err := c.command(fmt.Sprintf("UID FETCH %d BODY.PEEK[]", uid))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		m := imapFetchUIDPattern.FindStringSubmatch(line.text)
+		if m == nil || m[1] != strconv.FormatUint(uint64(uid), 10) || len(line.literals) == 0 {
+			continue
+		}
+		if line.literals[0] == nil {
+			return nil, errIMAPTooLarge
+		}
+		return line.literals[0], nil
+	}
+	return nil, errIMAPNoMessage
+}
+
+func (c *imapClient) logout() {
+	c.command("LOGOUT")
+	c.close()
+}
+
+func (c *imapClient) close() {
+	close(c.done)
+	c.conn.Close()
+}
+
+// command sends a command and returns the untagged responses to it, or
+// an error if it didn't complete with OK.
+func (c *imapClient) command(cmd string) ([]imapLine, error) {
+	c.tag++
+	tag := fmt.Sprintf("A%04d", c.tag)
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := io.WriteString(c.conn, tag+" "+cmd+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	var lines []imapLine
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line.text, tag+" ") {
+			lines = append(lines, line)
+			continue
+		}
+		status := strings.TrimPrefix(line.text, tag+" ")
+		if !strings.HasPrefix(strings.ToUpper(status), "OK") {
+			verb, _, _ := strings.Cut(cmd, " ")
+			return nil, fmt.Errorf("IMAP %s failed: %s", verb, status)
+		}
+		return lines, nil
+	}
+}
+
+// readLine reads a response line, reading the literals it announces
+// with {n} as it goes.
+func (c *imapClient) readLine() (imapLine, error) {
+	var line imapLine
+	for {
+		part, err := c.r.ReadString('\n')
+		if err != nil {
+			return line, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+
+		m := imapLiteralPattern.FindStringSubmatchIndex(part)
+		if m == nil {
+			line.text += part
+			return line, nil
+		}
+		size, err := strconv.ParseInt(part[m[2]:m[3]], 10, 64)
+		if err != nil {
+			return line, err
+		}
+		// A literal too large to index is skipped and given as nil.
+		var literal []byte
+		if size <= maxFileSize {
+			literal = make([]byte, size)
+			_, err = io.ReadFull(c.r, literal)
+		} else {
+			_, err = io.CopyN(io.Discard, c.r, size)
+		}
+		if err != nil {
+			return line, err
+		}
+		line.text += part[:m[0]]
+		line.literals = append(line.literals, literal)
+	}
+}
+
+// imapQuote makes an IMAP quoted string.
+func imapQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
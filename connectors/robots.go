@@ -0,0 +1,138 @@
+package connectors
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// robots holds the robots.txt rules that apply to the crawler on one
+// host. A host without robots.txt allows everything.
+type robots struct {
+	rules    []robotsRule
+	delay    time.Duration
+	sitemaps []string
+}
+
+type robotsRule struct {
+	allow   bool
+	length  int
+	pattern *regexp.Regexp
+}
+
+// parseRobots reads a robots.txt, keeping the group for agent, the
+// crawler's product token, or the "*" group if there is none for it.
+func parseRobots(r io.Reader, agent string) *robots {
+	agent = strings.ToLower(agent)
+
+	type group struct {
+		rules []robotsRule
+		delay time.Duration
+	}
+	var specific, wildcard *group
+	var current []*group
+	inAgents := false
+	result := &robots{}
+
+	scanner := bufio.NewScanner(io.LimitReader(r, 512*1024))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		switch name {
+		case "user-agent":
+			if !inAgents {
+				current = nil
+			}
+			inAgents = true
+			ua := strings.ToLower(value)
+			switch {
+			case ua == "*":
+				if wildcard == nil {
+					wildcard = &group{}
+				}
+				current = append(current, wildcard)
+			case ua != "" && strings.Contains(agent, ua):
+				if specific == nil {
+					specific = &group{}
+				}
+				current = append(current, specific)
+			}
+		case "allow", "disallow":
+			inAgents = false
+			if value == "" {
+				// "Disallow:" with no path allows everything.
+				continue
+			}
+			rule := robotsRule{allow: name == "allow", length: len(value), pattern: robotsPattern(value)}
+			for _, g := range current {
+				g.rules = append(g.rules, rule)
+			}
+		case "crawl-delay":
+			inAgents = false
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				for _, g := range current {
+					g.delay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			result.sitemaps = append(result.sitemaps, value)
+		default:
+			inAgents = false
+		}
+	}
+
+	if g := specific; g != nil || wildcard != nil {
+		if g == nil {
+			g = wildcard
+		}
+		result.rules = g.rules
+		result.delay = g.delay
+	}
+	return result
+}
+
+// allowed reports whether a path, with its query, may be crawled: the
+// longest matching rule decides, and Allow wins a tie.
+func (r *robots) allowed(path string) bool {
+	best := -1
+	allow := true
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > best || (rule.length == best && rule.allow) {
+			best = rule.length
+			allow = rule.allow
+		}
+	}
+	return allow
+}
+
+// robotsPattern turns a robots.txt path, where "*" matches anything and
+// a final "$" anchors the end, into a regular expression.
+func robotsPattern(path string) *regexp.Regexp {
+	anchored := strings.HasSuffix(path, "$")
+	path = strings.TrimSuffix(path, "$")
+
+	parts := strings.Split(path, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
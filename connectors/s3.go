@@ -0,0 +1,181 @@
+package connectors
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/shallowseek/ingest"
+)
+
+// S3Config says where the S3 connector finds documents. Locations are
+// "bucket" or "bucket/prefix".
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+	Locations []string
+	Interval  time.Duration
+}
+
+type s3Location struct {
+	bucket string
+	prefix string
+}
+
+// S3 indexes the supported objects under bucket prefixes of an S3 or
+// S3-compatible store. Each sync lists the prefixes and downloads the
+// objects whose ETag or LastModified changed; objects no longer listed
+// are deleted from the index. Documents have "bucket/key" as Path.
+type S3 struct {
+	*source
+	client    *s3Client
+	locations []s3Location
+	interval  time.Duration
+}
+
+func NewS3(cfg S3Config, index Index, stateDir string) (*S3, error) {
+	client, err := newS3Client(cfg.Endpoint, cfg.Region, cfg.AccessKey, cfg.SecretKey, cfg.PathStyle)
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []s3Location
+	for _, loc := range cfg.Locations {
+		bucket, prefix, _ := strings.Cut(strings.Trim(loc, "/"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid S3 location: %q", loc)
+		}
+		if prefix != "" {
+			prefix += "/"
+		}
+		locations = append(locations, s3Location{bucket: bucket, prefix: prefix})
+	}
+
+	src, err := newSource("s3", index, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	return &S3{source: src, client: client, locations: locations, interval: cfg.Interval}, nil
+}
+
+func (c *S3) Start() {
+	c.schedule(c.interval, c.syncAll)
+}
+
+func (c *S3) syncAll() error {
+	var failed error
+	for _, loc := range c.locations {
+		if c.stopped() {
+			return nil
+		}
+		if err := c.syncLocation(loc); err != nil {
+			log.Printf("[S3] Error syncing %s/%s: %v", loc.bucket, loc.prefix, err)
+			failed = err
+		}
+	}
+	if failed != nil || c.stopped() {
+		return failed
+	}
+
+	// Objects of locations dropped from the configuration go too.
+	for _, key := range c.checkpoint.keys("") {
+		if !c.configured(key) {
+			if err := c.remove(key); err != nil {
+				return err
+			}
+			log.Printf("[S3] Removed %s: no longer configured", key)
+		}
+	}
+	return nil
+}
+
+// syncLocation indexes what changed under one prefix. Deletions are only
+// mirrored once the whole prefix was listed.
+func (c *S3) syncLocation(loc s3Location) error {
+	seen := make(map[string]bool)
+	err := c.client.list(c.ctx, loc.bucket, loc.prefix, func(obj s3Object) error {
+		if c.stopped() {
+			return errStopped
+		}
+		if strings.HasSuffix(obj.Key, "/") || !ingest.IsSupported(path.Ext(obj.Key)) {
+			return nil
+		}
+
+		key := loc.bucket + "/" + obj.Key
+		seen[key] = true
+		if obj.Size > maxFileSize {
+			log.Printf("[S3] Skipping %s: too large (%d bytes)", key, obj.Size)
+			return nil
+		}
+		version := obj.ETag + "@" + obj.LastModified.UTC().Format(time.RFC3339)
+		if c.upToDate(key, version) {
+			return nil
+		}
+
+		if err := c.syncObject(loc.bucket, obj.Key, version); err != nil {
+			if c.stopped() {
+				return errStopped
+			}
+			// Keep the previous version; the next sync tries again.
+			log.Printf("[S3] Error indexing %s: %v", key, err)
+		}
+		return nil
+	})
+	if err == errStopped {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, key := range c.checkpoint.keys(loc.bucket + "/" + loc.prefix) {
+		if seen[key] {
+			continue
+		}
+		if err := c.remove(key); err != nil {
+			return err
+		}
+		log.Printf("[S3] Removed %s", key)
+	}
+	c.save()
+	return nil
+}
+
+func (c *S3) syncObject(bucket, objectKey, version string) error {
+	key := bucket + "/" + objectKey
+	body, err := c.client.get(c.ctx, bucket, objectKey)
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(io.LimitReader(body, maxFileSize))
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	docs, err := ingest.Documents(key, content)
+	if err != nil {
+		log.Printf("[S3] Error extracting text from %s: %v", key, err)
+		return c.put(key, version, nil)
+	}
+	if err := c.put(key, version, docs); err != nil {
+		return err
+	}
+	log.Printf("[S3] Indexed %s (%d documents)", key, len(docs))
+	return nil
+}
+
+func (c *S3) configured(key string) bool {
+	for _, loc := range c.locations {
+		if strings.HasPrefix(key, loc.bucket+"/"+loc.prefix) {
+			return true
+		}
+	}
+	return false
+}
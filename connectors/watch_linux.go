@@ -0,0 +1,108 @@
+//go:build linux
+
+package connectors
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// watcher reports changes in directories through inotify. Each event is
+// the path of the changed entry; an empty path means events were lost.
+type watcher struct {
+	fd     int
+	file   *os.File
+	events chan string
+	done   chan struct{}
+
+	mu   sync.Mutex
+	dirs map[int32]string
+}
+
+func newWatcher() (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %v", err)
+	}
+	w := &watcher{
+		fd:     fd,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan string, 1024),
+		done:   make(chan struct{}),
+		dirs:   make(map[int32]string),
+	}
+	go w.read()
+	return w, nil
+}
+
+// add watches a directory. Watching it again is harmless.
+func (w *watcher) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+	if err != nil {
+		if err == syscall.ENOSPC {
+			return fmt.Errorf("inotify watch limit reached, raise fs.inotify.max_user_watches")
+		}
+		return err
+	}
+	w.mu.Lock()
+	w.dirs[int32(wd)] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *watcher) close() {
+	close(w.done)
+	w.file.Close()
+}
+
+func (w *watcher) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[offset:]))
+			mask := binary.NativeEndian.Uint32(buf[offset+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[offset+12:]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+			offset = nameStart + nameLen
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				w.send("")
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.dirs[wd]
+			if mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, wd)
+			}
+			w.mu.Unlock()
+
+			// A new file is reported again once it has been written.
+			if !ok || name == "" || (mask&syscall.IN_CREATE != 0 && mask&syscall.IN_ISDIR == 0) {
+				continue
+			}
+			w.send(filepath.Join(dir, name))
+		}
+	}
+}
+
+func (w *watcher) send(path string) {
+	select {
+	case w.events <- path:
+	case <-w.done:
+	}
+}
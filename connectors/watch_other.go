@@ -0,0 +1,19 @@
+//go:build !linux
+
+package connectors
+
+import "errors"
+
+type watcher struct {
+	events chan string
+}
+
+func newWatcher() (*watcher, error) {
+	return nil, errors.New("change notifications are only supported on Linux")
+}
+
+func (w *watcher) add(dir string) error {
+	return nil
+}
+
+func (w *watcher) close() {}
@@ -0,0 +1,202 @@
+package connectors
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/shallowseek/ingest"
+)
+
+// WebDAVConfig says which WebDAV tree the WebDAV connector indexes. For
+// a Nextcloud share, URL is like
+// https://cloud.example.com/remote.php/dav/files/<user>/<folder>.
+type WebDAVConfig struct {
+	URL      string
+	Username string
+	Password string
+	Interval time.Duration
+}
+
+// WebDAV indexes the supported files under a WebDAV collection. Each
+// sync walks the tree with PROPFIND and downloads the files whose ETag
+// changed; files no longer there are deleted from the index. Documents
+// have the file's URL as Path. Hidden files and collections are skipped.
+type WebDAV struct {
+	*source
+	client   *webdavClient
+	root     *url.URL
+	interval time.Duration
+}
+
+func NewWebDAV(cfg WebDAVConfig, index Index, stateDir string) (*WebDAV, error) {
+	root, err := url.Parse(cfg.URL)
+	if err != nil || root.Host == "" || (root.Scheme != "http" && root.Scheme != "https") {
+		return nil, fmt.Errorf("invalid WebDAV URL: %q", cfg.URL)
+	}
+	root.User = nil
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+		root.RawPath = ""
+	}
+
+	src, err := newSource("webdav", index, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	return &WebDAV{
+		source:   src,
+		client:   newWebDAVClient(cfg.Username, cfg.Password),
+		root:     root,
+		interval: cfg.Interval,
+	}, nil
+}
+
+func (c *WebDAV) Start() {
+	c.schedule(c.interval, c.syncAll)
+}
+
+func (c *WebDAV) syncAll() error {
+	// Keys are relative to the root, so they mean nothing under another.
+	if previous := c.checkpoint.getState("root"); previous != "" && previous != c.root.String() {
+		log.Printf("[WebDAV] Root changed from %s to %s, indexing it again", previous, c.root)
+		for _, key := range c.checkpoint.keys("") {
+			if err := c.remove(key); err != nil {
+				return err
+			}
+		}
+	}
+	c.checkpoint.setState("root", c.root.String())
+
+	seen := make(map[string]bool)
+	var unreadable []string
+	visited := make(map[string]bool)
+	queue := []*url.URL{c.root}
+	for len(queue) > 0 {
+		if c.stopped() {
+			return nil
+		}
+		dir := queue[0]
+		queue = queue[1:]
+		dirKey := c.key(dir)
+		if visited[dirKey] {
+			continue
+		}
+		visited[dirKey] = true
+
+		entries, err := c.client.list(c.ctx, dir)
+		if err != nil {
+			if c.stopped() {
+				return nil
+			}
+			if dir == c.root {
+				return err
+			}
+			// Leave the files under it alone until it can be read again.
+			log.Printf("[WebDAV] Error listing %s: %v", dir.Redacted(), err)
+			unreadable = append(unreadable, dirKey)
+			continue
+		}
+
+		for _, entry := range entries {
+			key := c.key(entry.URL)
+			if key == "" || !c.inRoot(entry.URL) {
+				continue
+			}
+			if strings.HasPrefix(path.Base(key), ".") {
+				continue
+			}
+			if entry.Collection {
+				queue = append(queue, entry.URL)
+				continue
+			}
+			if !ingest.IsSupported(path.Ext(key)) {
+				continue
+			}
+
+			seen[key] = true
+			if entry.Size > maxFileSize {
+				log.Printf("[WebDAV] Skipping %s: too large (%d bytes)", key, entry.Size)
+				continue
+			}
+			version := entry.ETag
+			if version == "" {
+				version = fmt.Sprintf("%d@%s", entry.Size, entry.LastModified)
+			}
+			if c.upToDate(key, version) {
+				continue
+			}
+
+			if err := c.syncFile(key, entry.URL, version); err != nil {
+				if c.stopped() {
+					return nil
+				}
+				// Keep the previous version; the next sync tries again.
+				log.Printf("[WebDAV] Error indexing %s: %v", key, err)
+			}
+		}
+	}
+
+	for _, key := range c.checkpoint.keys("") {
+		if seen[key] || underCollection(key, unreadable) {
+			continue
+		}
+		if err := c.remove(key); err != nil {
+			return err
+		}
+		log.Printf("[WebDAV] Removed %s", key)
+	}
+	c.save()
+	return nil
+}
+
+func (c *WebDAV) syncFile(key string, u *url.URL, version string) error {
+	body, err := c.client.get(c.ctx, u)
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(io.LimitReader(body, maxFileSize))
+	body.Close()
+	if err != nil {
+		return err
+	}
+
+	docs, err := ingest.Documents(u.String(), content)
+	if err != nil {
+		log.Printf("[WebDAV] Error extracting text from %s: %v", key, err)
+		return c.put(key, version, nil)
+	}
+	if err := c.put(key, version, docs); err != nil {
+		return err
+	}
+	log.Printf("[WebDAV] Indexed %s (%d documents)", key, len(docs))
+	return nil
+}
+
+// key returns the path of a file or collection relative to the root,
+// without the trailing slash of collections.
+func (c *WebDAV) key(u *url.URL) string {
+	return strings.Trim(strings.TrimPrefix(u.Path, c.root.Path), "/")
+}
+
+// inRoot reports whether a URL from a PROPFIND response is under the
+// root. The credentials are sent to every URL listed, so one on another
+// host or scheme is never followed.
+func (c *WebDAV) inRoot(u *url.URL) bool {
+	return u.Scheme == c.root.Scheme && strings.EqualFold(u.Host, c.root.Host) && strings.HasPrefix(u.Path, c.root.Path)
+}
+
+// underCollection reports whether key is one of dirs or inside one of
+// them.
+func underCollection(key string, dirs []string) bool {
+	for _, dir := range dirs {
+		if key == dir || strings.HasPrefix(key, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
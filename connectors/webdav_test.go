@@ -0,0 +1,79 @@
+package connectors
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// davResponseXML is one member of a PROPFIND multistatus.
+func davResponseXML(href string, collection bool, etag string) string {
+	resourceType := ""
+	if collection {
+		resourceType = "<d:collection/>"
+	}
+	return fmt.Sprintf(`<d:response><d:href>%s</d:href><d:propstat><d:prop>`+
+		`<d:resourcetype>%s</d:resourcetype><d:getetag>"%s"</d:getetag><d:getcontentlength>5</d:getcontentlength>`+
+		`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, href, resourceType, etag)
+}
+
+// A PROPFIND response may name URLs on any host; the connector must only
+// follow those under its root, or it hands its credentials out.
+func TestWebDAVIgnoresOtherHosts(t *testing.T) {
+	var mu sync.Mutex
+	var foreign []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		user, _, _ := r.BasicAuth()
+		foreign = append(foreign, r.Method+" "+r.URL.Path+" as "+user)
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:"></d:multistatus>`)
+	}))
+	defer other.Close()
+
+	share := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "PROPFIND" && r.URL.Path == "/dav/files/user/":
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:">`+
+				davResponseXML("/dav/files/user/", true, "root")+
+				davResponseXML("/dav/files/user/notes.txt", false, "n1")+
+				davResponseXML(other.URL+"/dav/files/user/steal.txt", false, "s1")+
+				davResponseXML(other.URL+"/dav/files/user/more/", true, "m1")+
+				davResponseXML("https://"+r.Host+"/dav/files/user/tls.txt", false, "t1")+
+				`</d:multistatus>`)
+		case r.Method == http.MethodGet && r.URL.Path == "/dav/files/user/notes.txt":
+			fmt.Fprint(w, "notes")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer share.Close()
+
+	index := newFakeIndex()
+	conn, err := NewWebDAV(WebDAVConfig{
+		URL:      share.URL + "/dav/files/user",
+		Username: "user",
+		Password: "secret",
+	}, index, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.syncAll(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	assertStrings(t, "indexed", index.paths(), []string{share.URL + "/dav/files/user/notes.txt"})
+	mu.Lock()
+	defer mu.Unlock()
+	if len(foreign) > 0 {
+		t.Errorf("requests sent to another host: %q", foreign)
+	}
+}
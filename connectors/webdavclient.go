@@ -0,0 +1,143 @@
+package connectors
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getetag/>
+    <d:getcontentlength/>
+    <d:getlastmodified/>
+  </d:prop>
+</d:propfind>`
+
+// webdavClient is the little of WebDAV the connector needs: listing a
+// collection with PROPFIND and downloading files, with Basic auth.
+type webdavClient struct {
+	username string
+	password string
+	http     *http.Client
+}
+
+// davEntry is a member of a collection.
+type davEntry struct {
+	URL          *url.URL
+	Collection   bool
+	ETag         string
+	Size         int64
+	LastModified string
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ETag          string `xml:"DAV: getetag"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+}
+
+func newWebDAVClient(username, password string) *webdavClient {
+	return &webdavClient{
+		username: username,
+		password: password,
+		http:     &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// list returns the members of a collection, without the collection.
+func (c *webdavClient) list(ctx context.Context, u *url.URL) ([]davEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, "PROPFIND", u.String(), strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s: %s", u.Redacted(), res.Status)
+	}
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %v", err)
+	}
+
+	self := strings.TrimSuffix(u.Path, "/")
+	var entries []davEntry
+	for _, r := range ms.Responses {
+		href, err := u.Parse(r.Href)
+		if err != nil || strings.TrimSuffix(href.Path, "/") == self {
+			continue
+		}
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			size, _ := strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64)
+			entries = append(entries, davEntry{
+				URL:          href,
+				Collection:   ps.Prop.ResourceType.Collection != nil,
+				ETag:         strings.TrimSpace(ps.Prop.ETag),
+				Size:         size,
+				LastModified: strings.TrimSpace(ps.Prop.LastModified),
+			})
+			break
+		}
+	}
+	return entries, nil
+}
+
+// get returns the body of a file; the caller closes it.
+func (c *webdavClient) get(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u.Redacted(), res.Status)
+	}
+	return res.Body, nil
+}
+
+func (c *webdavClient) do(req *http.Request) (*http.Response, error) {
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return c.http.Do(req)
+}
@@ -37,6 +37,10 @@ func Init() error {
 
         settings := map[string]interface{}{
             "settings": map[string]interface{}{
+                // Every passage is a nested document. Ingest caps them
+                // at 10000 a document, which is also the default limit,
+                // so the limit is raised explicitly with room to spare.
+                "index.mapping.nested_objects.limit": 20000,
                 "analysis": map[string]interface{}{
                     "analyzer": map[string]interface{}{
                         "custom_analyzer": map[string]interface{}{
@@ -107,10 +111,6 @@ func Init() error {
                         "term_vector":     "with_positions_offsets",
                         "index_options":   "positions",
                         "fields": map[string]interface{}{
-                            "keyword": map[string]interface{}{
-                                "type":         "keyword",
-                                "ignore_above": 256,
-                            },
                             "elser": map[string]interface{}{
                                 "type": "text",
                                 "analyzer": "standard",
@@ -120,6 +120,124 @@ func Init() error {
                     "original_content": map[string]interface{}{
                         "type": "binary",
                     },
+                    "encoding": map[string]interface{}{
+                        "type": "keyword",
+                    },
+                    "ocr": map[string]interface{}{
+                        "type": "boolean",
+                    },
+                    "ocr_confidence": map[string]interface{}{
+                        "type": "float",
+                    },
+                    "passages": map[string]interface{}{
+                        "type": "nested",
+                        "properties": map[string]interface{}{
+                            "text": map[string]interface{}{
+                                "type":          "text",
+                                "analyzer":      "custom_analyzer",
+                                "index_options": "offsets",
+                            },
+                            "location": map[string]interface{}{
+                                "type": "keyword",
+                            },
+                            "sheet": map[string]interface{}{
+                                "type": "keyword",
+                            },
+                            "row": map[string]interface{}{
+                                "type": "integer",
+                            },
+                            "column": map[string]interface{}{
+                                "type": "keyword",
+                            },
+                            "slide": map[string]interface{}{
+                                "type": "integer",
+                            },
+                            "page": map[string]interface{}{
+                                "type": "integer",
+                            },
+                            "start": map[string]interface{}{
+                                "type": "integer",
+                            },
+                            "end": map[string]interface{}{
+                                "type": "integer",
+                            },
+                        },
+                    },
+                    "from": map[string]interface{}{
+                        "type":     "text",
+                        "analyzer": "standard",
+                        "fields": map[string]interface{}{
+                            "keyword": map[string]interface{}{
+                                "type":         "keyword",
+                                "ignore_above": 256,
+                            },
+                        },
+                    },
+                    "to": map[string]interface{}{
+                        "type":     "text",
+                        "analyzer": "standard",
+                    },
+                    "subject": map[string]interface{}{
+                        "type":     "text",
+                        "analyzer": "custom_analyzer",
+                    },
+                    "date": map[string]interface{}{
+                        "type": "date",
+                    },
+                    "chat": map[string]interface{}{
+                        "type":     "text",
+                        "analyzer": "standard",
+                        "fields": map[string]interface{}{
+                            "keyword": map[string]interface{}{
+                                "type":         "keyword",
+                                "ignore_above": 256,
+                            },
+                        },
+                    },
+                    "message_id": map[string]interface{}{
+                        "type": "keyword",
+                    },
+                    "parent_id": map[string]interface{}{
+                        "type": "keyword",
+                    },
+                    "source": map[string]interface{}{
+                        "type": "keyword",
+                    },
+                    "title": map[string]interface{}{
+                        "type":     "text",
+                        "analyzer": "custom_analyzer",
+                        "fields": map[string]interface{}{
+                            "keyword": map[string]interface{}{
+                                "type":         "keyword",
+                                "ignore_above": 256,
+                            },
+                        },
+                    },
+                    "author": map[string]interface{}{
+                        "type":     "text",
+                        "analyzer": "standard",
+                        "fields": map[string]interface{}{
+                            "keyword": map[string]interface{}{
+                                "type":         "keyword",
+                                "ignore_above": 256,
+                            },
+                        },
+                    },
+                    "created": map[string]interface{}{
+                        "type": "date",
+                    },
+                    "modified": map[string]interface{}{
+                        "type": "date",
+                    },
+                    "page_count": map[string]interface{}{
+                        "type": "integer",
+                    },
+                    "language": map[string]interface{}{
+                        "type": "keyword",
+                    },
+                    "producer": map[string]interface{}{
+                        "type": "keyword",
+                    },
                     "indexed": map[string]interface{}{
                         "type": "date",
                     },
@@ -211,4 +329,178 @@ func GetDocumentCount() (int64, error) {
     }
 
     return 0, fmt.Errorf("invalid count response format")
-} 
\ No newline at end of file
+}
+
+// GetIndexSettings returns the current values of the named settings of
+// the documents index, such as "index.refresh_interval". Settings left
+// at their default are returned with the default value.
+func GetIndexSettings(names ...string) (map[string]string, error) {
+    res, err := Client.Indices.GetSettings(
+        Client.Indices.GetSettings.WithIndex("documents"),
+        Client.Indices.GetSettings.WithName(names...),
+        Client.Indices.GetSettings.WithFlatSettings(true),
+        Client.Indices.GetSettings.WithIncludeDefaults(true),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return nil, fmt.Errorf("error getting index settings: %s", res.String())
+    }
+
+    var body map[string]struct {
+        Settings map[string]string `json:"settings"`
+        Defaults map[string]string `json:"defaults"`
+    }
+    if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+        return nil, err
+    }
+
+    index := body["documents"]
+    settings := make(map[string]string, len(names))
+    for _, name := range names {
+        if value, ok := index.Settings[name]; ok {
+            settings[name] = value
+        } else if value, ok := index.Defaults[name]; ok {
+            settings[name] = value
+        }
+    }
+    return settings, nil
+}
+
+// UpdateIndexSettings sets dynamic settings of the documents index,
+// given by their full names.
+func UpdateIndexSettings(settings map[string]string) error {
+    body, err := json.Marshal(settings)
+    if err != nil {
+        return err
+    }
+
+    res, err := Client.Indices.PutSettings(
+        strings.NewReader(string(body)),
+        Client.Indices.PutSettings.WithIndex("documents"),
+    )
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return fmt.Errorf("error updating index settings: %s", res.String())
+    }
+    return nil
+}
+
+func RefreshIndex() error {
+    res, err := Client.Indices.Refresh(
+        Client.Indices.Refresh.WithIndex("documents"),
+    )
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return fmt.Errorf("error refreshing index: %s", res.String())
+    }
+    return nil
+}
+
+// ChildDocumentIDs returns the IDs of the documents whose parent_id is
+// one of ids, such as the attachments of an email.
+func ChildDocumentIDs(ids []string) ([]string, error) {
+    query := map[string]interface{}{
+        "size":    10000,
+        "_source": false,
+        "query": map[string]interface{}{
+            "terms": map[string]interface{}{
+                "parent_id": ids,
+            },
+        },
+    }
+    body, err := json.Marshal(query)
+    if err != nil {
+        return nil, err
+    }
+
+    res, err := Client.Search(
+        Client.Search.WithIndex("documents"),
+        Client.Search.WithBody(strings.NewReader(string(body))),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return nil, fmt.Errorf("error searching child documents: %s", res.String())
+    }
+
+    var result struct {
+        Hits struct {
+            Hits []struct {
+                ID string `json:"_id"`
+            } `json:"hits"`
+        } `json:"hits"`
+    }
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return nil, err
+    }
+
+    children := make([]string, 0, len(result.Hits.Hits))
+    for _, hit := range result.Hits.Hits {
+        children = append(children, hit.ID)
+    }
+    return children, nil
+}
+
+// DeleteDocuments deletes documents by ID and returns the IDs of those
+// that existed. refresh is the refresh parameter of the request; it
+// must be "false" while the index doesn't refresh on its own, or the
+// request waits until something refreshes it.
+func DeleteDocuments(ids []string, refresh string) ([]string, error) {
+    var buf strings.Builder
+    for _, id := range ids {
+        meta, err := json.Marshal(map[string]interface{}{
+            "delete": map[string]interface{}{"_index": "documents", "_id": id},
+        })
+        if err != nil {
+            return nil, err
+        }
+        buf.Write(meta)
+        buf.WriteByte('\n')
+    }
+
+    res, err := Client.Bulk(
+        strings.NewReader(buf.String()),
+        Client.Bulk.WithRefresh(refresh),
+    )
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    if res.IsError() {
+        return nil, fmt.Errorf("error deleting documents: %s", res.String())
+    }
+
+    var result struct {
+        Items []map[string]struct {
+            ID     string `json:"_id"`
+            Result string `json:"result"`
+        } `json:"items"`
+    }
+    if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+        return nil, err
+    }
+
+    var deleted []string
+    for _, item := range result.Items {
+        if del, ok := item["delete"]; ok && del.Result == "deleted" {
+            deleted = append(deleted, del.ID)
+        }
+    }
+    return deleted, nil
+}
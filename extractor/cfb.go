@@ -0,0 +1,243 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Reader for the Compound File Binary format (OLE2 structured storage)
+// that Outlook .msg files are stored in. Only what reading a message
+// needs is implemented: the FAT, mini FAT and directory tree.
+
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+const (
+	cfbEndOfChain = 0xFFFFFFFE
+	cfbFreeSect   = 0xFFFFFFFF
+	cfbNoStream   = 0xFFFFFFFF
+
+	cfbTypeStorage = 1
+	cfbTypeStream  = 2
+	cfbTypeRoot    = 5
+)
+
+type cfbEntry struct {
+	name     string
+	kind     byte
+	left     uint32
+	right    uint32
+	child    uint32
+	start    uint32
+	size     uint64
+	children map[string]*cfbEntry
+}
+
+type cfbFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFAT        []uint32
+	miniStream     []byte
+	entries        []*cfbEntry
+}
+
+func openCFB(data []byte) (*cfbFile, error) {
+	if len(data) < 512 || !bytes.HasPrefix(data, cfbSignature) {
+		return nil, fmt.Errorf("not a compound file")
+	}
+
+	le := binary.LittleEndian
+	f := &cfbFile{
+		data:           data,
+		sectorSize:     1 << le.Uint16(data[0x1E:]),
+		miniSectorSize: 1 << le.Uint16(data[0x20:]),
+		miniCutoff:     uint64(le.Uint32(data[0x38:])),
+	}
+	if f.sectorSize != 512 && f.sectorSize != 4096 {
+		return nil, fmt.Errorf("invalid sector size %d", f.sectorSize)
+	}
+
+	// The DIFAT lists the sectors holding the FAT: 109 entries in the
+	// header, the rest in a chain of DIFAT sectors. The file can't hold
+	// more FAT sectors than it has sectors.
+	numFAT := int(le.Uint32(data[0x2C:]))
+	if maxSectors := len(data) / f.sectorSize; numFAT > maxSectors {
+		return nil, fmt.Errorf("invalid FAT sector count %d", numFAT)
+	}
+	var fatSectors []uint32
+	for i := 0; i < 109 && len(fatSectors) < numFAT; i++ {
+		fatSectors = append(fatSectors, le.Uint32(data[0x4C+i*4:]))
+	}
+	perSector := f.sectorSize/4 - 1
+	seen := map[uint32]bool{}
+	for sect := le.Uint32(data[0x44:]); sect < cfbEndOfChain && len(fatSectors) < numFAT; {
+		if seen[sect] {
+			return nil, fmt.Errorf("DIFAT chain loop at sector %d", sect)
+		}
+		seen[sect] = true
+		buf, err := f.sector(sect)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector && len(fatSectors) < numFAT; i++ {
+			fatSectors = append(fatSectors, le.Uint32(buf[i*4:]))
+		}
+		sect = le.Uint32(buf[perSector*4:])
+	}
+
+	// The FAT is read from the file, so it can't be larger than it.
+	maxFAT := len(data) / 4
+	for _, sect := range fatSectors {
+		if len(f.fat)+f.sectorSize/4 > maxFAT {
+			return nil, fmt.Errorf("FAT larger than the file")
+		}
+		buf, err := f.sector(sect)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < f.sectorSize; i += 4 {
+			f.fat = append(f.fat, le.Uint32(buf[i:]))
+		}
+	}
+
+	dir, err := f.readChain(le.Uint32(data[0x30:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %v", err)
+	}
+	for off := 0; off+128 <= len(dir); off += 128 {
+		e := dir[off : off+128]
+		nameLen := int(le.Uint16(e[64:]))
+		if nameLen > 64 {
+			nameLen = 64
+		}
+		units := make([]uint16, 0, nameLen/2)
+		for i := 0; i+1 < nameLen; i += 2 {
+			if u := le.Uint16(e[i:]); u != 0 {
+				units = append(units, u)
+			}
+		}
+		f.entries = append(f.entries, &cfbEntry{
+			name:  string(utf16.Decode(units)),
+			kind:  e[66],
+			left:  le.Uint32(e[68:]),
+			right: le.Uint32(e[72:]),
+			child: le.Uint32(e[76:]),
+			start: le.Uint32(e[116:]),
+			size:  le.Uint64(e[120:]),
+		})
+	}
+	if len(f.entries) == 0 || f.entries[0].kind != cfbTypeRoot {
+		return nil, fmt.Errorf("missing root directory entry")
+	}
+	if f.sectorSize == 512 {
+		// Version 3 files may leave garbage in the high half of the size.
+		for _, e := range f.entries {
+			e.size &= 0xFFFFFFFF
+		}
+	}
+
+	if miniFAT, err := f.readChain(le.Uint32(data[0x3C:]), 0); err == nil {
+		for i := 0; i+4 <= len(miniFAT); i += 4 {
+			f.miniFAT = append(f.miniFAT, le.Uint32(miniFAT[i:]))
+		}
+	}
+	root := f.entries[0]
+	if f.miniStream, err = f.readChain(root.start, root.size); err != nil {
+		return nil, fmt.Errorf("error reading mini stream: %v", err)
+	}
+
+	return f, nil
+}
+
+func (f *cfbFile) sector(n uint32) ([]byte, error) {
+	off := (int(n) + 1) * f.sectorSize
+	if n >= cfbEndOfChain || off+f.sectorSize > len(f.data) {
+		return nil, fmt.Errorf("sector %d out of range", n)
+	}
+	return f.data[off : off+f.sectorSize], nil
+}
+
+// readChain follows a FAT chain from start and returns up to size bytes
+// (all of it when size is 0).
+func (f *cfbFile) readChain(start uint32, size uint64) ([]byte, error) {
+	var out []byte
+	for sect, steps := start, 0; sect < cfbEndOfChain; steps++ {
+		if steps > len(f.fat) {
+			return nil, fmt.Errorf("FAT chain loop at sector %d", sect)
+		}
+		buf, err := f.sector(sect)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, buf...)
+		if int(sect) >= len(f.fat) {
+			break
+		}
+		sect = f.fat[sect]
+	}
+	if size > 0 && uint64(len(out)) > size {
+		out = out[:size]
+	}
+	return out, nil
+}
+
+func (f *cfbFile) readMiniChain(start uint32, size uint64) ([]byte, error) {
+	var out []byte
+	for sect, steps := start, 0; sect < cfbEndOfChain && uint64(len(out)) < size; steps++ {
+		off := int(sect) * f.miniSectorSize
+		if steps > len(f.miniFAT) || off+f.miniSectorSize > len(f.miniStream) {
+			return nil, fmt.Errorf("mini sector %d out of range", sect)
+		}
+		out = append(out, f.miniStream[off:off+f.miniSectorSize]...)
+		if int(sect) >= len(f.miniFAT) {
+			break
+		}
+		sect = f.miniFAT[sect]
+	}
+	if uint64(len(out)) > size {
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// stream returns the contents of a stream entry.
+func (f *cfbFile) stream(e *cfbEntry) ([]byte, error) {
+	if e.kind != cfbTypeStream {
+		return nil, fmt.Errorf("%s is not a stream", e.name)
+	}
+	if e.size == 0 {
+		return nil, nil
+	}
+	if e.size < f.miniCutoff {
+		return f.readMiniChain(e.start, e.size)
+	}
+	return f.readChain(e.start, e.size)
+}
+
+// children returns the entries directly inside a storage, by name.
+func (f *cfbFile) children(e *cfbEntry) map[string]*cfbEntry {
+	if e.children != nil {
+		return e.children
+	}
+	e.children = map[string]*cfbEntry{}
+
+	// Siblings form a red-black tree rooted at the storage's child.
+	seen := map[uint32]bool{}
+	stack := []uint32{e.child}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == cfbNoStream || int(id) >= len(f.entries) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		child := f.entries[id]
+		e.children[child.name] = child
+		stack = append(stack, child.left, child.right)
+	}
+	return e.children
+}
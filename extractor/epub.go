@@ -0,0 +1,108 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/shallowseek/models"
+)
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Title    string `xml:"metadata>title"`
+	Creator  string `xml:"metadata>creator"`
+	Language string `xml:"metadata>language"`
+	Date     string `xml:"metadata>date"`
+	Manifest []struct {
+		ID        string `xml:"id,attr"`
+		Href      string `xml:"href,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef  string `xml:"idref,attr"`
+		Linear string `xml:"linear,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// extractEPUB reads the chapters of an EPUB book in spine (reading)
+// order and joins their text with blank lines between chapters.
+func extractEPUB(content []byte) (*Result, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening EPUB archive: %v", err)
+	}
+
+	data, err := readZipFile(zr, "META-INF/container.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading EPUB container: %v", err)
+	}
+	var container epubContainer
+	if err := xml.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("error parsing EPUB container: %v", err)
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("EPUB container lists no package document")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+	data, err = readZipFile(zr, opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading EPUB package: %v", err)
+	}
+	var pkg epubPackage
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("error parsing EPUB package: %v", err)
+	}
+
+	hrefs := make(map[string]string, len(pkg.Manifest))
+	for _, item := range pkg.Manifest {
+		if strings.Contains(item.MediaType, "html") {
+			hrefs[item.ID] = item.Href
+		}
+	}
+
+	base := path.Dir(opfPath)
+	var chapters []string
+	if title := strings.TrimSpace(pkg.Title); title != "" {
+		chapters = append(chapters, title)
+	}
+	for _, ref := range pkg.Spine {
+		href, ok := hrefs[ref.IDRef]
+		if !ok || ref.Linear == "no" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+
+		data, err := readZipFile(zr, path.Join(base, href))
+		if err != nil {
+			log.Printf("[Extract] Skipping EPUB chapter %s: %v", href, err)
+			continue
+		}
+		if text := htmlToText(string(data)); text != "" {
+			chapters = append(chapters, text)
+		}
+	}
+
+	return &Result{
+		Text: strings.Join(chapters, "\n\n"),
+		Metadata: models.Metadata{
+			Title:    strings.TrimSpace(pkg.Title),
+			Author:   strings.TrimSpace(pkg.Creator),
+			Language: normalizeLanguage(pkg.Language),
+			Created:  parseMetadataTime(pkg.Date),
+		},
+	}, nil
+}
@@ -0,0 +1,177 @@
+package extractor
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/shallowseek/models"
+)
+
+// Result is the text extracted from an uploaded file.
+type Result struct {
+	Text string
+	// Encoding is the detected character encoding of plain text input.
+	// It is empty for formats that are not decoded as text.
+	Encoding string
+	// OCR is set when the text was recognised from page images rather
+	// than read from the file, with the mean Tesseract word confidence
+	// (0-100) in OCRConfidence.
+	OCR           bool
+	OCRConfidence float64
+	// Passages locate pieces of the text inside the original file, such
+	// as spreadsheet cells or slides. Formats without such structure
+	// leave it empty.
+	Passages []models.Passage
+	// Metadata is the descriptive information stored in the file itself.
+	Metadata models.Metadata
+}
+
+// Extract turns the raw bytes of a file with the given extension into
+// indexable UTF-8 text and reads the metadata stored in it.
+func Extract(ext string, content []byte) (*Result, error) {
+	ext = strings.ToLower(ext)
+	result, err := extractText(ext, content)
+	if err != nil {
+		return nil, err
+	}
+	readMetadata(ext, content, result)
+	return result, nil
+}
+
+func extractText(ext string, content []byte) (*Result, error) {
+	switch ext {
+	case ".txt":
+		return extractPlainText(content)
+	case ".pdf":
+		return extractPDF(content)
+	case ".png", ".jpg", ".jpeg", ".tif", ".tiff":
+		return extractImage(ext, content)
+	case ".html", ".htm":
+		return extractHTML(content)
+	case ".md", ".markdown":
+		return extractMarkup(content, markdownToText)
+	case ".odt":
+		return extractODT(content)
+	case ".rtf":
+		return extractRTF(content)
+	case ".epub":
+		return extractEPUB(content)
+	case ".xlsx":
+		return extractXLSX(content)
+	case ".ods":
+		return extractODS(content)
+	case ".csv":
+		return extractCSV(content)
+	case ".pptx":
+		return extractPPTX(content)
+	default:
+		return &Result{Text: string(content)}, nil
+	}
+}
+
+func extractPlainText(content []byte) (*Result, error) {
+	encoding := DetectEncoding(content)
+	text, err := DecodeText(content, encoding)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s text: %v", encoding, err)
+	}
+
+	log.Printf("[Extract] Decoded %d bytes of %s text", len(content), encoding)
+	return &Result{Text: text, Encoding: encoding}, nil
+}
+
+// extractMarkup decodes a text-based markup file and strips the markup
+// with the given converter.
+func extractMarkup(content []byte, toText func(string) string) (*Result, error) {
+	result, err := extractPlainText(content)
+	if err != nil {
+		return nil, err
+	}
+	result.Text = toText(result.Text)
+	return result, nil
+}
+
+func extractHTML(content []byte) (*Result, error) {
+	result, err := extractPlainText(content)
+	if err != nil {
+		return nil, err
+	}
+	result.Metadata.Title = htmlTitle(result.Text)
+	result.Text = htmlToText(result.Text)
+	return result, nil
+}
+
+func extractPDF(content []byte) (*Result, error) {
+	tmpFile, err := os.CreateTemp("", "upload-*.pdf")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if _, err := tmpFile.Write(content); err != nil {
+		return nil, fmt.Errorf("error writing to temp file: %v", err)
+	}
+
+	cmd := exec.Command("pdftotext", tmpFile.Name(), "-")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error extracting text from PDF: %v", err)
+	}
+
+	meta := pdfMetadata(tmpFile.Name())
+
+	text := out.String()
+	if strings.TrimSpace(text) != "" {
+		log.Printf("[Extract] Extracted %d bytes of text from PDF", len(text))
+		return &Result{Text: text, Passages: pagePassages(text), Metadata: meta}, nil
+	}
+
+	log.Printf("[Extract] No text layer in PDF, falling back to OCR")
+	result, err := ocrPDF(tmpFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	result.Metadata = meta
+	result.Passages = pagePassages(result.Text)
+	if strings.TrimSpace(result.Text) == "" {
+		log.Printf("[Extract] Warning: No text extracted from PDF")
+		result.Text = "PDF document (no text content extracted)"
+	}
+	return result, nil
+}
+
+// pagePassages splits PDF text into one passage per page on the form
+// feeds pdftotext (and our OCR stage) put between pages.
+func pagePassages(text string) []models.Passage {
+	var passages []models.Passage
+	for i, page := range strings.Split(text, "\f") {
+		page = strings.TrimSpace(page)
+		if page == "" {
+			continue
+		}
+		passages = append(passages, models.Passage{
+			Text:     page,
+			Location: fmt.Sprintf("Page %d", i+1),
+			Page:     i + 1,
+		})
+	}
+	return passages
+}
+
+func extractImage(ext string, content []byte) (*Result, error) {
+	result, err := ocrImage(ext, content)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(result.Text) == "" {
+		log.Printf("[Extract] Warning: No text recognised in image")
+		result.Text = "Image (no text content recognised)"
+	}
+	return result, nil
+}
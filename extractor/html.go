@@ -0,0 +1,122 @@
+package extractor
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Elements whose content is never visible text.
+var htmlSkipElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"template": true,
+	"svg":      true,
+	"head":     true,
+	"iframe":   true,
+	"object":   true,
+}
+
+// Page furniture that repeats on every page of a site and would make all
+// of them match the same queries.
+var htmlBoilerplateElements = map[string]bool{
+	"nav":    true,
+	"header": true,
+	"footer": true,
+	"aside":  true,
+	"form":   true,
+	"menu":   true,
+}
+
+// Elements that start a new line in the extracted text.
+var htmlBlockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "hr": true, "li": true, "tr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"section": true, "article": true, "main": true, "blockquote": true,
+	"pre": true, "table": true, "ul": true, "ol": true, "dl": true,
+	"dt": true, "dd": true, "figcaption": true, "title": true,
+}
+
+var (
+	htmlTagPattern     = regexp.MustCompile(`(?s)<!--.*?-->|<!\[CDATA\[.*?\]\]>|<![^>]*>|<\?[^>]*>|</?([a-zA-Z][a-zA-Z0-9:-]*)[^>]*?(/?)>`)
+	htmlTitlePattern   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlContentPattern = regexp.MustCompile(`(?is)<(main|article)[\s>].*</(main|article)>`)
+	blankLinesPattern  = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+	spacesPattern      = regexp.MustCompile(`[ \t\r\f\v\x{00A0}]+`)
+)
+
+// htmlToText returns the readable text of an HTML page. Scripts, styles
+// and navigation blocks are dropped, and when the page marks up its main
+// content with <main> or <article> only that part is kept.
+func htmlToText(src string) string {
+	body := src
+	if m := htmlContentPattern.FindString(src); m != "" {
+		body = m
+	}
+
+	var sb strings.Builder
+	var skip []string
+	last := 0
+	for _, loc := range htmlTagPattern.FindAllStringSubmatchIndex(body, -1) {
+		if len(skip) == 0 {
+			sb.WriteString(html.UnescapeString(body[last:loc[0]]))
+		}
+		last = loc[1]
+
+		if loc[2] < 0 {
+			continue
+		}
+		tag := body[loc[0]:loc[1]]
+		name := strings.ToLower(body[loc[2]:loc[3]])
+		closing := strings.HasPrefix(tag, "</")
+		selfClosing := loc[5] > loc[4]
+
+		if htmlSkipElements[name] || htmlBoilerplateElements[name] {
+			switch {
+			case closing:
+				if n := len(skip); n > 0 && skip[n-1] == name {
+					skip = skip[:n-1]
+				}
+			case !selfClosing:
+				skip = append(skip, name)
+			}
+			continue
+		}
+
+		if len(skip) == 0 && htmlBlockElements[name] {
+			sb.WriteString("\n")
+		}
+	}
+	if len(skip) == 0 {
+		sb.WriteString(html.UnescapeString(body[last:]))
+	}
+
+	text := sb.String()
+	if title := htmlTitle(src); title != "" && !strings.Contains(text, title) {
+		text = title + "\n" + text
+	}
+	return normalizeWhitespace(text)
+}
+
+// htmlTitle returns the contents of the page's <title> element.
+func htmlTitle(src string) string {
+	m := htmlTitlePattern.FindStringSubmatch(src)
+	if m == nil {
+		return ""
+	}
+	return strings.TrimSpace(normalizeWhitespace(html.UnescapeString(m[1])))
+}
+
+// normalizeWhitespace collapses runs of spaces and blank lines left over
+// after markup has been removed.
+func normalizeWhitespace(text string) string {
+	text = spacesPattern.ReplaceAllString(text, " ")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
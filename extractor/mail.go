@@ -0,0 +1,317 @@
+package extractor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Message is one e-mail read from an EML, MBOX or MSG file.
+type Message struct {
+	MessageID string
+	From      string
+	To        []string
+	Subject   string
+	Date      time.Time
+	Body      string
+	// Raw is the message on its own, suitable for downloading as .eml.
+	// It is empty for MSG files, which are kept whole.
+	Raw         []byte
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a Message. It is fed back through
+// Extract like any other upload.
+type Attachment struct {
+	Filename string
+	Content  []byte
+}
+
+// IsMailFormat reports whether files with this extension hold e-mail
+// messages that must be read with ExtractMessages.
+func IsMailFormat(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".eml", ".mbox", ".msg":
+		return true
+	}
+	return false
+}
+
+// ExtractMessages splits a mail file into its messages.
+func ExtractMessages(ext string, content []byte) ([]*Message, error) {
+	switch strings.ToLower(ext) {
+	case ".eml":
+		msg, err := parseRFC822(content)
+		if err != nil {
+			return nil, err
+		}
+		return []*Message{msg}, nil
+	case ".mbox":
+		return parseMbox(content)
+	case ".msg":
+		msg, err := parseMSG(content)
+		if err != nil {
+			return nil, err
+		}
+		return []*Message{msg}, nil
+	default:
+		return nil, fmt.Errorf("unsupported mail format: %s", ext)
+	}
+}
+
+// headerDecoder decodes RFC 2047 encoded words, including the legacy
+// Cyrillic charsets Go's mime package doesn't know about.
+var headerDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, ok := normalizeCharset(charset)
+		if !ok {
+			return nil, fmt.Errorf("unsupported charset: %s", charset)
+		}
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		text, err := DecodeText(data, enc)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(text), nil
+	},
+}
+
+// normalizeCharset maps a MIME charset label to one of our encodings.
+func normalizeCharset(charset string) (string, bool) {
+	switch strings.ToLower(strings.Trim(charset, `"' `)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return EncodingUTF8, true
+	case "windows-1251", "cp1251", "x-cp1251", "win-1251":
+		return EncodingCP1251, true
+	case "koi8-r", "koi8r", "koi8":
+		return EncodingKOI8R, true
+	case "cp866", "ibm866", "866":
+		return EncodingCP866, true
+	case "utf-16le":
+		return EncodingUTF16LE, true
+	case "utf-16be", "utf-16":
+		return EncodingUTF16BE, true
+	}
+	return "", false
+}
+
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return strings.TrimSpace(decoded)
+}
+
+func decodeAddressList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	parser := mail.AddressParser{WordDecoder: headerDecoder}
+	addrs, err := parser.ParseList(value)
+	if err != nil {
+		return []string{decodeHeader(value)}
+	}
+	out := make([]string, len(addrs))
+	for i, addr := range addrs {
+		out[i] = addr.Address
+		if addr.Name != "" {
+			out[i] = addr.Name + " <" + addr.Address + ">"
+		}
+	}
+	return out
+}
+
+// parseRFC822 reads a single Internet message with its MIME parts.
+func parseRFC822(raw []byte) (*Message, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing message: %v", err)
+	}
+
+	msg := &Message{
+		MessageID: strings.Trim(m.Header.Get("Message-Id"), "<> "),
+		Subject:   decodeHeader(m.Header.Get("Subject")),
+		To:        decodeAddressList(m.Header.Get("To")),
+		Raw:       raw,
+	}
+	if from := decodeAddressList(m.Header.Get("From")); len(from) > 0 {
+		msg.From = from[0]
+	}
+	if date, err := m.Header.Date(); err == nil {
+		msg.Date = date
+	}
+
+	var plain, html []string
+	if err := walkPart(m.Header, m.Body, msg, &plain, &html); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(plain) > 0:
+		msg.Body = strings.Join(plain, "\n\n")
+	case len(html) > 0:
+		msg.Body = htmlToText(strings.Join(html, "\n"))
+	}
+	return msg, nil
+}
+
+// walkPart collects the text bodies and attachments of a MIME entity,
+// descending into multipart containers.
+func walkPart(header mimeHeader, body io.Reader, msg *Message, plain, html *[]string) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading MIME part: %v", err)
+			}
+			if err := walkPart(part.Header, part, msg, plain, html); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(transferDecoder(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("error decoding MIME part: %v", err)
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := decodeHeader(dispParams["filename"])
+	if filename == "" {
+		filename = decodeHeader(params["name"])
+	}
+
+	isText := mediaType == "text/plain" || mediaType == "text/html"
+	if disposition == "attachment" || (filename != "" && !isText) || mediaType == "message/rfc822" {
+		if filename == "" {
+			filename = fmt.Sprintf("attachment-%d%s", len(msg.Attachments)+1, attachmentExt(mediaType))
+		}
+		msg.Attachments = append(msg.Attachments, Attachment{Filename: filepath.Base(filename), Content: data})
+		return nil
+	}
+	if !isText {
+		return nil
+	}
+
+	text := decodePartText(data, params["charset"])
+	if mediaType == "text/html" {
+		*html = append(*html, text)
+	} else {
+		*plain = append(*plain, text)
+	}
+	return nil
+}
+
+// mimeHeader is the common interface of mail.Header and
+// textproto.MIMEHeader that walkPart needs.
+type mimeHeader interface {
+	Get(key string) string
+}
+
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
+
+func decodePartText(data []byte, charset string) string {
+	enc, ok := normalizeCharset(charset)
+	if !ok || (enc == EncodingUTF8 && !utf8.Valid(data)) {
+		enc = DetectEncoding(data)
+	}
+	text, err := DecodeText(data, enc)
+	if err != nil {
+		return string(data)
+	}
+	return text
+}
+
+func attachmentExt(mediaType string) string {
+	if mediaType == "message/rfc822" {
+		return ".eml"
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// parseMbox splits an mbox file on its "From " separator lines and
+// parses each message. Messages that fail to parse are skipped so one
+// damaged entry doesn't lose the whole mailbox.
+func parseMbox(content []byte) ([]*Message, error) {
+	var messages []*Message
+	var current bytes.Buffer
+	started := false
+
+	finish := func() {
+		if current.Len() == 0 {
+			return
+		}
+		raw := append([]byte(nil), current.Bytes()...)
+		current.Reset()
+		msg, err := parseRFC822(raw)
+		if err != nil {
+			log.Printf("[Extract] Skipping mbox message %d: %v", len(messages)+1, err)
+			return
+		}
+		messages = append(messages, msg)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if bytes.HasPrefix(line, []byte("From ")) {
+			finish()
+			started = true
+			continue
+		}
+		if !started {
+			continue
+		}
+		// Undo mboxrd quoting of body lines that start with "From ".
+		if bytes.HasPrefix(line, []byte(">")) && bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			line = line[1:]
+		}
+		current.Write(line)
+		current.WriteString("\r\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading mbox: %v", err)
+	}
+	finish()
+
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no messages found in mbox")
+	}
+	return messages, nil
+}
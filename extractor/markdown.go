@@ -0,0 +1,65 @@
+package extractor
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdFencePattern      = regexp.MustCompile("^\\s*(```|~~~)")
+	mdHeadingPattern    = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	mdListPattern       = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s+(\[[ xX]\]\s+)?`)
+	mdQuotePattern      = regexp.MustCompile(`^\s{0,3}(>\s?)+`)
+	mdRulePattern       = regexp.MustCompile(`^\s{0,3}([-*_]\s*){3,}$`)
+	mdTableRulePattern  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	mdImagePattern      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkPattern       = regexp.MustCompile(`\[([^\]]*)\](\([^)]*\)|\[[^\]]*\])`)
+	mdLinkRefPattern    = regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s+\S+.*$`)
+	mdEmphasisPattern   = regexp.MustCompile(`(\*\*|__|\*|_|~~)([^*_~\s](?:.*?[^*_~\s])?)(\*\*|__|\*|_|~~)`)
+	mdInlineCodePattern = regexp.MustCompile("`+([^`]*)`+")
+	mdAutolinkPattern   = regexp.MustCompile(`<(https?://[^>]+)>`)
+)
+
+// markdownToText strips Markdown syntax and keeps the prose, link texts,
+// image alt texts and the contents of code blocks.
+func markdownToText(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+
+	var out []string
+	inFence := false
+	for _, line := range strings.Split(src, "\n") {
+		if mdFencePattern.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+
+		if mdRulePattern.MatchString(line) || mdTableRulePattern.MatchString(line) || mdLinkRefPattern.MatchString(line) {
+			out = append(out, "")
+			continue
+		}
+
+		if m := mdHeadingPattern.FindStringSubmatch(line); m != nil {
+			line = m[1]
+		}
+		line = mdQuotePattern.ReplaceAllString(line, "")
+		line = mdListPattern.ReplaceAllString(line, "")
+		line = mdImagePattern.ReplaceAllString(line, "$1")
+		line = mdLinkPattern.ReplaceAllString(line, "$1")
+		line = mdAutolinkPattern.ReplaceAllString(line, "$1")
+		line = mdInlineCodePattern.ReplaceAllString(line, "$1")
+		line = mdEmphasisPattern.ReplaceAllString(line, "$2")
+		line = strings.Trim(strings.ReplaceAll(line, "|", " "), " ")
+
+		out = append(out, line)
+	}
+
+	text := strings.Join(out, "\n")
+	if strings.Contains(text, "<") {
+		text = htmlToText(text)
+	}
+	return normalizeWhitespace(text)
+}
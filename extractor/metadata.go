@@ -0,0 +1,218 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/shallowseek/models"
+)
+
+// readMetadata fills in the metadata stored inside office documents.
+// Failures are not errors: a file without readable metadata is still
+// worth indexing for its text.
+func readMetadata(ext string, content []byte, result *Result) {
+	switch ext {
+	case ".docx", ".xlsx", ".pptx":
+		if zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content))); err == nil {
+			result.Metadata = ooxmlMetadata(zr)
+		}
+	case ".odt", ".ods":
+		if zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content))); err == nil {
+			result.Metadata = odfMetadata(zr)
+		}
+	}
+
+	if result.Metadata.Language == "" {
+		result.Metadata.Language = detectLanguage(result.Text)
+	}
+}
+
+// pdfMetadata reads the document information dictionary with pdfinfo.
+func pdfMetadata(pdfPath string) models.Metadata {
+	var meta models.Metadata
+
+	cmd := exec.Command("pdfinfo", "-isodates", "-enc", "UTF-8", pdfPath)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return meta
+	}
+
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Title":
+			meta.Title = value
+		case "Author":
+			meta.Author = value
+		case "Producer":
+			meta.Producer = value
+		case "Creator":
+			if meta.Producer == "" {
+				meta.Producer = value
+			}
+		case "CreationDate":
+			meta.Created = parseMetadataTime(value)
+		case "ModDate":
+			meta.Modified = parseMetadataTime(value)
+		case "Pages":
+			meta.PageCount, _ = strconv.Atoi(value)
+		}
+	}
+	return meta
+}
+
+type ooxmlCoreProperties struct {
+	Title          string `xml:"title"`
+	Creator        string `xml:"creator"`
+	LastModifiedBy string `xml:"lastModifiedBy"`
+	Created        string `xml:"created"`
+	Modified       string `xml:"modified"`
+	Language       string `xml:"language"`
+}
+
+type ooxmlAppProperties struct {
+	Application string `xml:"Application"`
+	AppVersion  string `xml:"AppVersion"`
+	Pages       int    `xml:"Pages"`
+	Slides      int    `xml:"Slides"`
+}
+
+// ooxmlMetadata reads docProps/core.xml and docProps/app.xml of a Word,
+// Excel or PowerPoint file.
+func ooxmlMetadata(zr *zip.Reader) models.Metadata {
+	var meta models.Metadata
+
+	if data, err := readZipFile(zr, "docProps/core.xml"); err == nil {
+		var core ooxmlCoreProperties
+		if xml.Unmarshal(data, &core) == nil {
+			meta.Title = strings.TrimSpace(core.Title)
+			meta.Author = strings.TrimSpace(core.Creator)
+			if meta.Author == "" {
+				meta.Author = strings.TrimSpace(core.LastModifiedBy)
+			}
+			meta.Created = parseMetadataTime(core.Created)
+			meta.Modified = parseMetadataTime(core.Modified)
+			meta.Language = normalizeLanguage(core.Language)
+		}
+	}
+
+	if data, err := readZipFile(zr, "docProps/app.xml"); err == nil {
+		var app ooxmlAppProperties
+		if xml.Unmarshal(data, &app) == nil {
+			meta.Producer = strings.TrimSpace(app.Application + " " + app.AppVersion)
+			meta.PageCount = app.Pages
+			if meta.PageCount == 0 {
+				meta.PageCount = app.Slides
+			}
+		}
+	}
+	return meta
+}
+
+type odfMeta struct {
+	Title          string `xml:"meta>title"`
+	InitialCreator string `xml:"meta>initial-creator"`
+	Creator        string `xml:"meta>creator"`
+	CreationDate   string `xml:"meta>creation-date"`
+	Date           string `xml:"meta>date"`
+	Language       string `xml:"meta>language"`
+	Generator      string `xml:"meta>generator"`
+	Statistic      struct {
+		PageCount int `xml:"page-count,attr"`
+	} `xml:"meta>document-statistic"`
+}
+
+// odfMetadata reads meta.xml of an OpenDocument file.
+func odfMetadata(zr *zip.Reader) models.Metadata {
+	var meta models.Metadata
+
+	data, err := readZipFile(zr, "meta.xml")
+	if err != nil {
+		return meta
+	}
+	var m odfMeta
+	if xml.Unmarshal(data, &m) != nil {
+		return meta
+	}
+
+	meta.Title = strings.TrimSpace(m.Title)
+	meta.Author = strings.TrimSpace(m.InitialCreator)
+	if meta.Author == "" {
+		meta.Author = strings.TrimSpace(m.Creator)
+	}
+	meta.Created = parseMetadataTime(m.CreationDate)
+	meta.Modified = parseMetadataTime(m.Date)
+	meta.Language = normalizeLanguage(m.Language)
+	meta.Producer = strings.TrimSpace(m.Generator)
+	meta.PageCount = m.Statistic.PageCount
+	return meta
+}
+
+var metadataTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func parseMetadataTime(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	for _, layout := range metadataTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// normalizeLanguage reduces a language tag such as "ru-RU" to its
+// primary subtag.
+func normalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag
+}
+
+// detectLanguage tells Russian from English text by the share of
+// Cyrillic letters. That is all the archive contains, and it's enough
+// to filter on.
+func detectLanguage(text string) string {
+	var cyrillic, latin int
+	for i, r := range text {
+		if i > 64*1024 {
+			break
+		}
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	switch {
+	case cyrillic+latin < 20:
+		return ""
+	case cyrillic >= latin:
+		return "ru"
+	default:
+		return "en"
+	}
+}
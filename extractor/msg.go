@@ -0,0 +1,272 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MAPI property tags read from Outlook .msg files.
+const (
+	propSubject          = 0x0037
+	propClientSubmitTime = 0x0039
+	propTransportHeaders = 0x007D
+	propSenderName       = 0x0C1A
+	propSenderEmail      = 0x0C1F
+	propDisplayTo        = 0x0E04
+	propBody             = 0x1000
+	propBodyHTML         = 0x1013
+	propInternetID       = 0x1035
+	propAttachData       = 0x3701
+	propAttachFilename   = 0x3704
+	propAttachLongName   = 0x3707
+	propSMTPAddress      = 0x39FE
+
+	propTypeString8 = 0x001E
+	propTypeUnicode = 0x001F
+	propTypeBinary  = 0x0102
+	propTypeSysTime = 0x0040
+	propTypeObject  = 0x000D
+)
+
+// maxAttachmentDepth bounds how deep attached Outlook items are read
+// into, like the limit ingest puts on attachments of attachments.
+const maxAttachmentDepth = 5
+
+type msgStorage struct {
+	f     *cfbFile
+	entry *cfbEntry
+}
+
+// prop reads a variable-length property stream as a string, trying the
+// Unicode variant first.
+func (s msgStorage) prop(id uint16) string {
+	children := s.f.children(s.entry)
+	if e, ok := children[fmt.Sprintf("__substg1.0_%04X%04X", id, propTypeUnicode)]; ok {
+		if data, err := s.f.stream(e); err == nil {
+			return strings.TrimRight(decodeUTF16(data, false), "\x00")
+		}
+	}
+	if e, ok := children[fmt.Sprintf("__substg1.0_%04X%04X", id, propTypeString8)]; ok {
+		if data, err := s.f.stream(e); err == nil {
+			return strings.TrimRight(decodePartText(data, ""), "\x00")
+		}
+	}
+	return ""
+}
+
+func (s msgStorage) binaryProp(id uint16) []byte {
+	e, ok := s.f.children(s.entry)[fmt.Sprintf("__substg1.0_%04X%04X", id, propTypeBinary)]
+	if !ok {
+		return nil
+	}
+	data, _ := s.f.stream(e)
+	return data
+}
+
+// timeProp reads a fixed-size PT_SYSTIME property from the storage's
+// property stream. headerSize is 32 for the top-level message and 24
+// for embedded messages.
+func (s msgStorage) timeProp(id uint16, headerSize int) time.Time {
+	e, ok := s.f.children(s.entry)["__properties_version1.0"]
+	if !ok {
+		return time.Time{}
+	}
+	data, err := s.f.stream(e)
+	if err != nil {
+		return time.Time{}
+	}
+	le := binary.LittleEndian
+	for off := headerSize; off+16 <= len(data); off += 16 {
+		tag := le.Uint32(data[off:])
+		if uint16(tag>>16) == id && uint16(tag) == propTypeSysTime {
+			return filetimeToTime(le.Uint64(data[off+8:]))
+		}
+	}
+	return time.Time{}
+}
+
+// filetimeToTime converts a Windows FILETIME (100ns ticks since 1601).
+func filetimeToTime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	const epochDelta = 116444736000000000
+	return time.Unix(0, int64(ft-epochDelta)*100).UTC()
+}
+
+func parseMSG(content []byte) (*Message, error) {
+	f, err := openCFB(content)
+	if err != nil {
+		return nil, fmt.Errorf("error opening MSG file: %v", err)
+	}
+	return readMSGStorage(msgStorage{f: f, entry: f.entries[0]}, 32, 0, map[*cfbEntry]bool{}), nil
+}
+
+// readMSGStorage reads the message in a storage. depth is how many
+// attached items it is nested in; visited holds the storages already
+// read, so a directory that links back to one can't loop.
+func readMSGStorage(s msgStorage, headerSize, depth int, visited map[*cfbEntry]bool) *Message {
+	visited[s.entry] = true
+	msg := &Message{
+		MessageID: strings.Trim(s.prop(propInternetID), "<> "),
+		Subject:   s.prop(propSubject),
+		Date:      s.timeProp(propClientSubmitTime, headerSize),
+		Body:      s.prop(propBody),
+	}
+
+	// Transport headers, present on received mail, carry the addresses
+	// in the same form as an EML file would.
+	if headers := s.prop(propTransportHeaders); headers != "" {
+		if m, err := mail.ReadMessage(strings.NewReader(headers + "\r\n\r\n")); err == nil {
+			if from := decodeAddressList(m.Header.Get("From")); len(from) > 0 {
+				msg.From = from[0]
+			}
+			msg.To = decodeAddressList(m.Header.Get("To"))
+			if msg.Date.IsZero() {
+				msg.Date, _ = m.Header.Date()
+			}
+		}
+	}
+	if msg.From == "" {
+		name, email := s.prop(propSenderName), s.prop(propSenderEmail)
+		if email == "" {
+			email = s.prop(propSMTPAddress)
+		}
+		msg.From = strings.TrimSpace(name + " <" + email + ">")
+		if email == "" {
+			msg.From = name
+		}
+	}
+	if len(msg.To) == 0 {
+		for _, to := range strings.Split(s.prop(propDisplayTo), ";") {
+			if to = strings.TrimSpace(to); to != "" {
+				msg.To = append(msg.To, to)
+			}
+		}
+	}
+	if msg.Body == "" {
+		if html := s.binaryProp(propBodyHTML); len(html) > 0 {
+			msg.Body = htmlToText(decodePartText(html, ""))
+		}
+	}
+
+	children := s.f.children(s.entry)
+	var attachNames []string
+	for name, child := range children {
+		if child.kind == cfbTypeStorage && strings.HasPrefix(name, "__attach_version1.0_") {
+			attachNames = append(attachNames, name)
+		}
+	}
+	sort.Strings(attachNames)
+	for _, name := range attachNames {
+		if att, ok := readMSGAttachment(msgStorage{f: s.f, entry: children[name]}, depth, visited); ok {
+			msg.Attachments = append(msg.Attachments, att)
+		}
+	}
+	return msg
+}
+
+func readMSGAttachment(s msgStorage, depth int, visited map[*cfbEntry]bool) (Attachment, bool) {
+	if visited[s.entry] {
+		log.Printf("[Extract] Skipping MSG attachment storage %q read before", s.entry.name)
+		return Attachment{}, false
+	}
+	visited[s.entry] = true
+
+	filename := s.prop(propAttachLongName)
+	if filename == "" {
+		filename = s.prop(propAttachFilename)
+	}
+
+	if data := s.binaryProp(propAttachData); data != nil {
+		if filename == "" {
+			filename = "attachment.bin"
+		}
+		return Attachment{Filename: filename, Content: data}, true
+	}
+
+	// Attached Outlook items are stored as a nested message storage.
+	// They are re-encoded as EML so the pipeline can read them back.
+	embedded, ok := s.f.children(s.entry)[fmt.Sprintf("__substg1.0_%04X%04X", propAttachData, propTypeObject)]
+	if !ok || embedded.kind != cfbTypeStorage {
+		log.Printf("[Extract] Skipping MSG attachment %q without data", filename)
+		return Attachment{}, false
+	}
+	if depth >= maxAttachmentDepth || visited[embedded] {
+		log.Printf("[Extract] Skipping MSG attachment %q: nested too deep or in a loop", filename)
+		return Attachment{}, false
+	}
+	inner := readMSGStorage(msgStorage{f: s.f, entry: embedded}, 24, depth+1, visited)
+	if filename == "" {
+		filename = "message"
+	}
+	return Attachment{Filename: strings.TrimSuffix(filename, ".msg") + ".eml", Content: encodeEML(inner)}, true
+}
+
+// encodeEML writes a minimal RFC 822 rendering of a message. Its
+// attachments follow the body as parts of a multipart/mixed message.
+func encodeEML(msg *Message) []byte {
+	var buf bytes.Buffer
+	writeHeader := func(name, value string) {
+		if value != "" {
+			buf.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	writeHeader("From", mime.BEncoding.Encode("utf-8", msg.From))
+	writeHeader("To", mime.BEncoding.Encode("utf-8", strings.Join(msg.To, ", ")))
+	writeHeader("Subject", mime.BEncoding.Encode("utf-8", msg.Subject))
+	if !msg.Date.IsZero() {
+		writeHeader("Date", msg.Date.Format(time.RFC1123Z))
+	}
+	if msg.MessageID != "" {
+		writeHeader("Message-Id", "<"+msg.MessageID+">")
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if len(msg.Attachments) == 0 {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+		writeBase64(&buf, []byte(msg.Body))
+		return buf.Bytes()
+	}
+
+	mw := multipart.NewWriter(&buf)
+	writeHeader("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+	part, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	writeBase64(part, []byte(msg.Body))
+	for _, att := range msg.Attachments {
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/octet-stream"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		writeBase64(part, att.Content)
+	}
+	mw.Close()
+	return buf.Bytes()
+}
+
+// writeBase64 writes data base64-encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
@@ -0,0 +1,171 @@
+package extractor
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// testEntry is a directory entry of a compound file built by buildCFB.
+// Siblings are linked through right; child is the first entry inside a
+// storage.
+type testEntry struct {
+	name  string
+	kind  byte
+	right uint32
+	child uint32
+	data  []byte
+}
+
+// buildCFB lays out a version 3 compound file: the FAT in sector 0, the
+// directory after it, then each stream. The mini stream cutoff is 0, so
+// every stream is read through the FAT.
+func buildCFB(entries []testEntry) []byte {
+	const sectorSize = 512
+	le := binary.LittleEndian
+
+	dirSectors := (len(entries) + 3) / 4
+	fat := []uint32{0xFFFFFFFD}
+	for i := 0; i < dirSectors; i++ {
+		fat = append(fat, uint32(len(fat)+1))
+	}
+	fat[len(fat)-1] = cfbEndOfChain
+
+	dir := make([]byte, dirSectors*sectorSize)
+	var streams []byte
+	for i, e := range entries {
+		d := dir[i*128 : (i+1)*128]
+		units := utf16.Encode([]rune(e.name))
+		for j, u := range units {
+			le.PutUint16(d[j*2:], u)
+		}
+		le.PutUint16(d[64:], uint16(len(units)*2+2))
+		d[66] = e.kind
+		le.PutUint32(d[68:], cfbNoStream)
+		le.PutUint32(d[72:], e.right)
+		le.PutUint32(d[76:], e.child)
+		le.PutUint32(d[116:], cfbEndOfChain)
+		if len(e.data) > 0 {
+			le.PutUint32(d[116:], uint32(len(fat)))
+			n := (len(e.data) + sectorSize - 1) / sectorSize
+			for j := 0; j < n; j++ {
+				fat = append(fat, uint32(len(fat)+1))
+			}
+			fat[len(fat)-1] = cfbEndOfChain
+			padded := make([]byte, n*sectorSize)
+			copy(padded, e.data)
+			streams = append(streams, padded...)
+		}
+		le.PutUint64(d[120:], uint64(len(e.data)))
+	}
+	if len(fat) > sectorSize/4 {
+		panic("test compound file too large")
+	}
+
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3E)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2C:], 1)
+	le.PutUint32(header[0x30:], 1)
+	le.PutUint32(header[0x3C:], cfbEndOfChain)
+	le.PutUint32(header[0x44:], cfbEndOfChain)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(header[0x4C+i*4:], cfbFreeSect)
+	}
+	le.PutUint32(header[0x4C:], 0)
+
+	fatSector := make([]byte, sectorSize)
+	for i := range fatSector {
+		fatSector[i] = 0xFF
+	}
+	for i, next := range fat {
+		le.PutUint32(fatSector[i*4:], next)
+	}
+
+	out := append(header, fatSector...)
+	out = append(out, dir...)
+	return append(out, streams...)
+}
+
+func unicodeProp(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, len(units)*2)
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(out[i*2:], u)
+	}
+	return out
+}
+
+// An attached item whose storage links back to the attachment holding
+// it must not be read over and over.
+func TestParseMSGCyclicEmbeddedMessage(t *testing.T) {
+	content := buildCFB([]testEntry{
+		{name: "Root Entry", kind: cfbTypeRoot, right: cfbNoStream, child: 1},
+		{name: "__substg1.0_0037001F", kind: cfbTypeStream, right: 2, child: cfbNoStream, data: unicodeProp("Loop")},
+		{name: "__attach_version1.0_#00000000", kind: cfbTypeStorage, right: cfbNoStream, child: 3},
+		{name: "__substg1.0_3701000D", kind: cfbTypeStorage, right: cfbNoStream, child: 2},
+	})
+
+	msg, err := parseMSG(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Loop" {
+		t.Errorf("Subject = %q, want Loop", msg.Subject)
+	}
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("got %d attachments, want the embedded message once", len(msg.Attachments))
+	}
+	inner, err := parseRFC822(msg.Attachments[0].Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inner.Attachments) != 0 {
+		t.Errorf("embedded message has %d attachments, want the loop cut", len(inner.Attachments))
+	}
+}
+
+// Attachments of an attached Outlook item are kept in the EML it is
+// re-encoded as.
+func TestParseMSGEmbeddedMessageAttachments(t *testing.T) {
+	content := buildCFB([]testEntry{
+		{name: "Root Entry", kind: cfbTypeRoot, right: cfbNoStream, child: 1},
+		{name: "__substg1.0_0037001F", kind: cfbTypeStream, right: 2, child: cfbNoStream, data: unicodeProp("Fwd: отчёт")},
+		{name: "__attach_version1.0_#00000000", kind: cfbTypeStorage, right: cfbNoStream, child: 3},
+		{name: "__substg1.0_3707001F", kind: cfbTypeStream, right: 4, child: cfbNoStream, data: unicodeProp("Отчёт.msg")},
+		{name: "__substg1.0_3701000D", kind: cfbTypeStorage, right: cfbNoStream, child: 5},
+		{name: "__substg1.0_0037001F", kind: cfbTypeStream, right: 6, child: cfbNoStream, data: unicodeProp("Отчёт за квартал")},
+		{name: "__substg1.0_1000001F", kind: cfbTypeStream, right: 7, child: cfbNoStream, data: unicodeProp("См. вложение.")},
+		{name: "__attach_version1.0_#00000000", kind: cfbTypeStorage, right: cfbNoStream, child: 8},
+		{name: "__substg1.0_3707001F", kind: cfbTypeStream, right: 9, child: cfbNoStream, data: unicodeProp("цифры.txt")},
+		{name: "__substg1.0_37010102", kind: cfbTypeStream, right: cfbNoStream, child: cfbNoStream, data: []byte(strings.Repeat("выручка 42\n", 100))},
+	})
+
+	msg, err := parseMSG(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Filename != "Отчёт.eml" {
+		t.Fatalf("attachments = %+v, want Отчёт.eml", msg.Attachments)
+	}
+
+	inner, err := parseRFC822(msg.Attachments[0].Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inner.Subject != "Отчёт за квартал" || inner.Body != "См. вложение." {
+		t.Errorf("embedded message = %q / %q", inner.Subject, inner.Body)
+	}
+	if len(inner.Attachments) != 1 {
+		t.Fatalf("embedded message has %d attachments, want 1", len(inner.Attachments))
+	}
+	att := inner.Attachments[0]
+	if att.Filename != "цифры.txt" || string(att.Content) != strings.Repeat("выручка 42\n", 100) {
+		t.Errorf("embedded attachment = %q, %d bytes", att.Filename, len(att.Content))
+	}
+}
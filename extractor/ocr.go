@@ -0,0 +1,146 @@
+package extractor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shallowseek/config"
+)
+
+// ocrResolution is the DPI scanned PDF pages are rendered at before
+// recognition. Tesseract is tuned for roughly 300 DPI input.
+const ocrResolution = 300
+
+// ocrPDF renders every page of a scanned PDF to an image and runs
+// Tesseract on each of them.
+func ocrPDF(pdfPath string) (*Result, error) {
+	dir, err := os.MkdirTemp("", "ocr-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating OCR directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command("pdftoppm", "-r", strconv.Itoa(ocrResolution), "-png", pdfPath, filepath.Join(dir, "page"))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error rendering PDF pages: %v: %s", err, stderr.String())
+	}
+
+	pages, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing rendered pages: %v", err)
+	}
+	// pdftoppm zero-pads page numbers, so lexical order is page order.
+	sort.Strings(pages)
+
+	var text strings.Builder
+	var confSum float64
+	var words int
+	for i, page := range pages {
+		pageText, pageConf, pageWords, err := runTesseract(page)
+		if err != nil {
+			return nil, fmt.Errorf("error recognising page %d: %v", i+1, err)
+		}
+		if i > 0 {
+			text.WriteString("\f")
+		}
+		text.WriteString(pageText)
+		confSum += pageConf * float64(pageWords)
+		words += pageWords
+	}
+
+	result := &Result{Text: text.String(), OCR: true}
+	if words > 0 {
+		result.OCRConfidence = confSum / float64(words)
+	}
+	log.Printf("[Extract] OCR recognised %d words on %d pages (confidence %.1f)", words, len(pages), result.OCRConfidence)
+	return result, nil
+}
+
+// ocrImage runs Tesseract on a single uploaded image.
+func ocrImage(ext string, content []byte) (*Result, error) {
+	tmpFile, err := os.CreateTemp("", "upload-*"+ext)
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	if _, err := tmpFile.Write(content); err != nil {
+		return nil, fmt.Errorf("error writing to temp file: %v", err)
+	}
+
+	text, conf, words, err := runTesseract(tmpFile.Name())
+	if err != nil {
+		return nil, fmt.Errorf("error recognising image: %v", err)
+	}
+
+	log.Printf("[Extract] OCR recognised %d words in image (confidence %.1f)", words, conf)
+	return &Result{Text: text, OCR: true, OCRConfidence: conf}, nil
+}
+
+// runTesseract recognises one image and returns its text together with
+// the mean word confidence (0-100) and the number of recognised words.
+// The TSV output is used rather than plain text because it is the only
+// one that carries per-word confidences.
+func runTesseract(imagePath string) (string, float64, int, error) {
+	cmd := exec.Command("tesseract", imagePath, "stdout", "-l", config.GetOCRLanguages(), "tsv")
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", 0, 0, fmt.Errorf("tesseract failed: %v: %s", err, stderr.String())
+	}
+
+	var text strings.Builder
+	var confSum float64
+	var words int
+	lastLine := ""
+
+	scanner := bufio.NewScanner(&out)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// level page block par line word left top width height conf text
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 12 || fields[0] != "5" {
+			continue
+		}
+
+		word := strings.TrimSpace(fields[11])
+		conf, err := strconv.ParseFloat(fields[10], 64)
+		if word == "" || err != nil || conf < 0 {
+			continue
+		}
+
+		line := strings.Join(fields[2:5], ".")
+		switch {
+		case text.Len() == 0:
+		case line != lastLine:
+			text.WriteString("\n")
+		default:
+			text.WriteString(" ")
+		}
+		lastLine = line
+
+		text.WriteString(word)
+		confSum += conf
+		words++
+	}
+	if err := scanner.Err(); err != nil {
+		return "", 0, 0, fmt.Errorf("error reading tesseract output: %v", err)
+	}
+
+	if words == 0 {
+		return "", 0, 0, nil
+	}
+	return text.String(), confSum / float64(words), words, nil
+}
@@ -0,0 +1,89 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Elements of an ODF content.xml that end a line of text.
+var odfBlockElements = map[string]bool{
+	"p":         true,
+	"h":         true,
+	"list-item": true,
+	"table-row": true,
+}
+
+func extractODT(content []byte) (*Result, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening ODT archive: %v", err)
+	}
+
+	data, err := readZipFile(zr, "content.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading ODT content: %v", err)
+	}
+
+	text, err := odfText(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing ODT content: %v", err)
+	}
+	return &Result{Text: text}, nil
+}
+
+// odfText flattens an OpenDocument XML part into plain text, one line
+// per paragraph, heading or table row.
+func odfText(data []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var sb strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "s":
+				sb.WriteString(" ")
+			case "tab":
+				sb.WriteString("\t")
+			case "table-cell":
+				sb.WriteString(" ")
+			case "line-break":
+				sb.WriteString("\n")
+			}
+		case xml.EndElement:
+			if odfBlockElements[t.Name.Local] {
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			sb.Write(t)
+		}
+	}
+	return normalizeWhitespace(sb.String()), nil
+}
+
+// readZipFile returns the contents of the named entry of an archive.
+func readZipFile(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
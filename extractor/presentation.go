@@ -0,0 +1,99 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shallowseek/models"
+)
+
+type pptxPresentation struct {
+	Slides []struct {
+		RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sldIdLst>sldId"`
+}
+
+// extractPPTX returns one passage per slide, in presentation order.
+func extractPPTX(content []byte) (*Result, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening PPTX archive: %v", err)
+	}
+
+	data, err := readZipFile(zr, "ppt/presentation.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading PPTX presentation: %v", err)
+	}
+	var pres pptxPresentation
+	if err := xml.Unmarshal(data, &pres); err != nil {
+		return nil, fmt.Errorf("error parsing PPTX presentation: %v", err)
+	}
+
+	targets, err := readRelationships(zr, "ppt/_rels/presentation.xml.rels", "ppt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading PPTX relationships: %v", err)
+	}
+
+	var passages []models.Passage
+	for i, slide := range pres.Slides {
+		data, err := readZipFile(zr, targets[slide.RID])
+		if err != nil {
+			return nil, fmt.Errorf("error reading slide %d: %v", i+1, err)
+		}
+		text, err := drawingMLText(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing slide %d: %v", i+1, err)
+		}
+		if text == "" {
+			continue
+		}
+
+		passages = append(passages, models.Passage{
+			Text:     text,
+			Location: fmt.Sprintf("Slide %d", i+1),
+			Slide:    i + 1,
+		})
+	}
+
+	return passagesResult(passages), nil
+}
+
+// drawingMLText collects the <a:t> runs of a slide, one line per
+// <a:p> paragraph.
+func drawingMLText(data []byte) (string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var sb strings.Builder
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "t" {
+				inText = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return normalizeWhitespace(sb.String()), nil
+}
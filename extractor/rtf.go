@@ -0,0 +1,224 @@
+package extractor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Destinations whose content is formatting data rather than text.
+var rtfSkipDestinations = map[string]bool{
+	"fonttbl":      true,
+	"colortbl":     true,
+	"stylesheet":   true,
+	"info":         true,
+	"pict":         true,
+	"object":       true,
+	"header":       true,
+	"footer":       true,
+	"headerl":      true,
+	"headerr":      true,
+	"footerl":      true,
+	"footerr":      true,
+	"listtable":    true,
+	"rsidtbl":      true,
+	"themedata":    true,
+	"datastore":    true,
+	"xmlnstbl":     true,
+	"generator":    true,
+	"latentstyles": true,
+}
+
+// Code pages an RTF file may declare with \ansicpg that we can decode.
+var rtfCodePages = map[int]string{
+	1251:  EncodingCP1251,
+	20866: EncodingKOI8R,
+	866:   EncodingCP866,
+}
+
+type rtfGroup struct {
+	skip   bool
+	ucSkip int
+}
+
+// extractRTF interprets just enough of the RTF control language to
+// recover the document text: groups, paragraph and tab controls,
+// \'hh escapes in the declared ANSI code page and \uN Unicode escapes.
+func extractRTF(content []byte) (*Result, error) {
+	src := string(content)
+	if !strings.HasPrefix(strings.TrimSpace(src), "{\\rtf") {
+		return nil, fmt.Errorf("not an RTF document")
+	}
+
+	codePage := EncodingCP1251
+	var sb strings.Builder
+	var pending []byte
+
+	// Hex escapes are buffered so multi-byte runs decode together.
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if text, err := DecodeText(pending, codePage); err == nil {
+			sb.WriteString(text)
+		}
+		pending = pending[:0]
+	}
+
+	stack := []rtfGroup{{ucSkip: 1}}
+	skipChars := 0
+
+	for i := 0; i < len(src); i++ {
+		cur := &stack[len(stack)-1]
+		ch := src[i]
+
+		switch ch {
+		case '{':
+			stack = append(stack, *cur)
+			continue
+		case '}':
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		case '\r', '\n':
+			continue
+		case '\\':
+		default:
+			if skipChars > 0 {
+				skipChars--
+				continue
+			}
+			if !cur.skip {
+				flush()
+				sb.WriteByte(ch)
+			}
+			continue
+		}
+
+		// Control symbol or control word.
+		if i+1 >= len(src) {
+			break
+		}
+		next := src[i+1]
+		if !isASCIILetter(next) {
+			i++
+			switch next {
+			case '\'':
+				if i+2 < len(src) {
+					if b, err := strconv.ParseUint(src[i+1:i+3], 16, 8); err == nil {
+						if skipChars > 0 {
+							skipChars--
+						} else if !cur.skip {
+							pending = append(pending, byte(b))
+						}
+					}
+					i += 2
+				}
+			case '*':
+				cur.skip = true
+			case '~':
+				if !cur.skip {
+					flush()
+					sb.WriteString(" ")
+				}
+			case '\\', '{', '}':
+				if !cur.skip {
+					flush()
+					sb.WriteByte(next)
+				}
+			case '\n', '\r':
+				if !cur.skip {
+					flush()
+					sb.WriteString("\n")
+				}
+			}
+			continue
+		}
+
+		j := i + 1
+		for j < len(src) && isASCIILetter(src[j]) {
+			j++
+		}
+		word := src[i+1 : j]
+		k := j
+		if k < len(src) && (src[k] == '-' || isASCIIDigit(src[k])) {
+			k++
+			for k < len(src) && isASCIIDigit(src[k]) {
+				k++
+			}
+		}
+		param, hasParam := 0, k > j
+		if hasParam {
+			param, _ = strconv.Atoi(src[j:k])
+		}
+		if k < len(src) && src[k] == ' ' {
+			k++
+		}
+		i = k - 1
+
+		if rtfSkipDestinations[word] {
+			cur.skip = true
+			continue
+		}
+
+		switch word {
+		case "ansicpg":
+			if enc, ok := rtfCodePages[param]; ok {
+				codePage = enc
+			}
+		case "uc":
+			cur.ucSkip = param
+		case "u":
+			if !cur.skip {
+				flush()
+				if param < 0 {
+					param += 65536
+				}
+				sb.WriteRune(rune(param))
+			}
+			skipChars = cur.ucSkip
+		case "par", "line", "sect", "page", "row":
+			if !cur.skip {
+				flush()
+				sb.WriteString("\n")
+			}
+		case "tab", "cell":
+			if !cur.skip {
+				flush()
+				sb.WriteString("\t")
+			}
+		case "emdash":
+			if !cur.skip {
+				flush()
+				sb.WriteString("—")
+			}
+		case "endash":
+			if !cur.skip {
+				flush()
+				sb.WriteString("–")
+			}
+		case "lquote", "rquote":
+			if !cur.skip {
+				flush()
+				sb.WriteString("'")
+			}
+		case "ldblquote", "rdblquote":
+			if !cur.skip {
+				flush()
+				sb.WriteString("\"")
+			}
+		}
+	}
+	flush()
+
+	return &Result{Text: normalizeWhitespace(sb.String())}, nil
+}
+
+func isASCIILetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isASCIIDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
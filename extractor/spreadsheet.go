@@ -0,0 +1,432 @@
+package extractor
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/shallowseek/models"
+)
+
+// Repeated ODS cells are expanded only up to this many copies; templates
+// often "repeat" an empty cell across all 16384 columns.
+const maxRepeatedCells = 64
+
+// Adjacent rows are grouped into passages of up to about this many
+// characters. A passage per row would give a large sheet more nested
+// documents than Elasticsearch accepts for one document (10000 by
+// default).
+const rowsPassageSize = 1000
+
+// cell is one non-empty spreadsheet cell at a 0-based column index.
+type cell struct {
+	col  int
+	text string
+}
+
+// sheetPassages turns the cells of one row into passages, one per run
+// of adjacent non-empty cells, each labelled with the sheet and row.
+func sheetPassages(sheet string, row int, cells []cell) []models.Passage {
+	var passages []models.Passage
+	var run []string
+	start := -1
+	prev := -2
+
+	emit := func() {
+		if len(run) == 0 {
+			return
+		}
+		passages = append(passages, models.Passage{
+			Text:     strings.Join(run, "\t"),
+			Location: sheetLocation(sheet, row),
+			Sheet:    sheet,
+			Row:      row,
+			Column:   columnName(start),
+		})
+		run = nil
+	}
+
+	for _, c := range cells {
+		if c.col != prev+1 {
+			emit()
+			start = c.col
+		}
+		run = append(run, c.text)
+		prev = c.col
+	}
+	emit()
+	return passages
+}
+
+// mergeRows groups the passages of consecutive rows of a sheet into
+// passages of up to rowsPassageSize characters, located by their first
+// cell and their range of rows.
+func mergeRows(passages []models.Passage) []models.Passage {
+	var merged []models.Passage
+	size := 0
+	for _, p := range passages {
+		n := utf8.RuneCountInString(p.Text)
+		if last := len(merged) - 1; last >= 0 && merged[last].Sheet == p.Sheet && size+1+n <= rowsPassageSize {
+			merged[last].Text += "\n" + p.Text
+			merged[last].Location = rowsLocation(p.Sheet, merged[last].Row, p.Row)
+			size += 1 + n
+			continue
+		}
+		merged = append(merged, p)
+		size = n
+	}
+	return merged
+}
+
+func rowsLocation(sheet string, first, last int) string {
+	switch {
+	case first == last:
+		return sheetLocation(sheet, first)
+	case sheet == "":
+		return fmt.Sprintf("Rows %d-%d", first, last)
+	}
+	return fmt.Sprintf("Sheet '%s', rows %d-%d", sheet, first, last)
+}
+
+func sheetLocation(sheet string, row int) string {
+	if sheet == "" {
+		return fmt.Sprintf("Row %d", row)
+	}
+	return fmt.Sprintf("Sheet '%s', row %d", sheet, row)
+}
+
+// columnName converts a 0-based column index to its letter name (A, B,
+// ..., Z, AA, ...).
+func columnName(col int) string {
+	name := ""
+	for col >= 0 {
+		name = string(rune('A'+col%26)) + name
+		col = col/26 - 1
+	}
+	return name
+}
+
+// columnIndex parses the column part of a cell reference such as "B14".
+func columnIndex(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
+
+// passagesResult builds a Result whose text is the concatenation of
+// the passages.
+func passagesResult(passages []models.Passage) *Result {
+	texts := make([]string, len(passages))
+	for i, p := range passages {
+		texts[i] = p.Text
+	}
+	return &Result{Text: strings.Join(texts, "\n"), Passages: passages}
+}
+
+type ooxmlRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// readRelationships maps relationship IDs of an OOXML part to the paths
+// of their targets inside the archive.
+func readRelationships(zr *zip.Reader, relsPath, baseDir string) (map[string]string, error) {
+	data, err := readZipFile(zr, relsPath)
+	if err != nil {
+		return nil, err
+	}
+	var rels ooxmlRelationships
+	if err := xml.Unmarshal(data, &rels); err != nil {
+		return nil, err
+	}
+
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join(baseDir, rel.Target)
+		}
+	}
+	return targets, nil
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRichText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (rt xlsxRichText) String() string {
+	if len(rt.Runs) == 0 {
+		return rt.T
+	}
+	var sb strings.Builder
+	for _, r := range rt.Runs {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxRichText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string       `xml:"r,attr"`
+			T      string       `xml:"t,attr"`
+			V      string       `xml:"v"`
+			Inline xlsxRichText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func extractXLSX(content []byte) (*Result, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening XLSX archive: %v", err)
+	}
+
+	data, err := readZipFile(zr, "xl/workbook.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading XLSX workbook: %v", err)
+	}
+	var workbook xlsxWorkbook
+	if err := xml.Unmarshal(data, &workbook); err != nil {
+		return nil, fmt.Errorf("error parsing XLSX workbook: %v", err)
+	}
+
+	targets, err := readRelationships(zr, "xl/_rels/workbook.xml.rels", "xl")
+	if err != nil {
+		return nil, fmt.Errorf("error reading XLSX relationships: %v", err)
+	}
+
+	var shared xlsxSharedStrings
+	if data, err := readZipFile(zr, "xl/sharedStrings.xml"); err == nil {
+		if err := xml.Unmarshal(data, &shared); err != nil {
+			return nil, fmt.Errorf("error parsing XLSX shared strings: %v", err)
+		}
+	}
+
+	var passages []models.Passage
+	for _, sheet := range workbook.Sheets {
+		data, err := readZipFile(zr, targets[sheet.RID])
+		if err != nil {
+			return nil, fmt.Errorf("error reading sheet %q: %v", sheet.Name, err)
+		}
+		var ws xlsxWorksheet
+		if err := xml.Unmarshal(data, &ws); err != nil {
+			return nil, fmt.Errorf("error parsing sheet %q: %v", sheet.Name, err)
+		}
+
+		for i, row := range ws.Rows {
+			rowNum := row.R
+			if rowNum == 0 {
+				rowNum = i + 1
+			}
+
+			var cells []cell
+			for j, c := range row.Cells {
+				var text string
+				switch c.T {
+				case "s":
+					if idx, err := strconv.Atoi(c.V); err == nil && idx >= 0 && idx < len(shared.Items) {
+						text = shared.Items[idx].String()
+					}
+				case "inlineStr":
+					text = c.Inline.String()
+				case "b":
+					text = map[string]string{"0": "FALSE", "1": "TRUE"}[c.V]
+				default:
+					text = c.V
+				}
+				if text = strings.TrimSpace(text); text == "" {
+					continue
+				}
+
+				col := j
+				if c.R != "" {
+					col = columnIndex(c.R)
+				}
+				cells = append(cells, cell{col: col, text: text})
+			}
+			passages = append(passages, sheetPassages(sheet.Name, rowNum, cells)...)
+		}
+	}
+
+	return passagesResult(mergeRows(passages)), nil
+}
+
+func extractODS(content []byte) (*Result, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening ODS archive: %v", err)
+	}
+
+	data, err := readZipFile(zr, "content.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading ODS content: %v", err)
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var passages []models.Passage
+	var sheet string
+	var cells []cell
+	var text strings.Builder
+	row, col := 0, 0
+	rowRepeat, cellRepeat := 1, 1
+	inCell := false
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ODS content: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "table":
+				sheet = odfAttr(t, "name")
+				row = 0
+			case "table-row":
+				cells = nil
+				col = 0
+				rowRepeat = odfRepeat(t, "number-rows-repeated")
+			case "table-cell", "covered-table-cell":
+				text.Reset()
+				inCell = true
+				cellRepeat = odfRepeat(t, "number-columns-repeated")
+			case "p":
+				if inCell && text.Len() > 0 {
+					text.WriteString("\n")
+				}
+			case "s":
+				text.WriteString(" ")
+			case "tab":
+				text.WriteString("\t")
+			}
+		case xml.CharData:
+			if inCell {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "table-cell", "covered-table-cell":
+				inCell = false
+				if value := strings.TrimSpace(text.String()); value != "" {
+					for i := 0; i < cellRepeat && i < maxRepeatedCells; i++ {
+						cells = append(cells, cell{col: col + i, text: value})
+					}
+				}
+				col += cellRepeat
+			case "table-row":
+				row++
+				if len(cells) > 0 {
+					passages = append(passages, sheetPassages(sheet, row, cells)...)
+				}
+				row += rowRepeat - 1
+			}
+		}
+	}
+
+	return passagesResult(mergeRows(passages)), nil
+}
+
+func odfAttr(el xml.StartElement, name string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func odfRepeat(el xml.StartElement, name string) int {
+	if n, err := strconv.Atoi(odfAttr(el, name)); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+func extractCSV(content []byte) (*Result, error) {
+	encoding := DetectEncoding(content)
+	text, err := DecodeText(content, encoding)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s text: %v", encoding, err)
+	}
+
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.Comma = detectDelimiter(text)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var passages []models.Passage
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing CSV row %d: %v", row, err)
+		}
+
+		var cells []cell
+		for col, value := range record {
+			if value = strings.TrimSpace(value); value != "" {
+				cells = append(cells, cell{col: col, text: value})
+			}
+		}
+		passages = append(passages, sheetPassages("", row, cells)...)
+	}
+
+	result := passagesResult(mergeRows(passages))
+	result.Encoding = encoding
+	return result, nil
+}
+
+// detectDelimiter picks the field separator that occurs most often in
+// the first line. Russian Excel exports use ';' because ',' is the
+// decimal separator.
+func detectDelimiter(text string) rune {
+	firstLine := text
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		firstLine = text[:i]
+	}
+
+	best, bestCount := ',', 0
+	for _, d := range []rune{',', ';', '\t', '|'} {
+		if n := strings.Count(firstLine, string(d)); n > bestCount {
+			best, bestCount = d, n
+		}
+	}
+	return best
+}
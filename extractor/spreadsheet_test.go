@@ -0,0 +1,42 @@
+package extractor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExtractCSVGroupsRows(t *testing.T) {
+	var csv strings.Builder
+	csv.WriteString("Артикул;Название;Цена\n")
+	for i := 1; i <= 20000; i++ {
+		fmt.Fprintf(&csv, "%d;Товар %d;%d,50\n", i, i, i%1000)
+	}
+
+	result, err := extractCSV([]byte(csv.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(result.Passages); n == 0 || n > 2000 {
+		t.Fatalf("got %d passages for 20001 rows, want them grouped", n)
+	}
+
+	first := result.Passages[0]
+	if first.Row != 1 || first.Column != "A" || !strings.HasPrefix(first.Location, "Rows 1-") {
+		t.Errorf("first passage at row %d, column %s, %q", first.Row, first.Column, first.Location)
+	}
+	if !strings.HasPrefix(first.Text, "Артикул\tНазвание\tЦена\n1\tТовар 1\t1,50\n") {
+		t.Errorf("first passage text = %q", first.Text)
+	}
+	for _, p := range result.Passages {
+		if len([]rune(p.Text)) > rowsPassageSize {
+			t.Errorf("passage %s has %d characters", p.Location, len([]rune(p.Text)))
+		}
+		if !strings.Contains(result.Text, p.Text) {
+			t.Errorf("passage %s is not in the text", p.Location)
+		}
+	}
+	if last := result.Passages[len(result.Passages)-1]; !strings.HasSuffix(last.Location, "-20001") {
+		t.Errorf("last passage at %q, want it to end at row 20001", last.Location)
+	}
+}
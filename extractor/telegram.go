@@ -0,0 +1,157 @@
+package extractor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chat is one conversation of a Telegram Desktop export.
+type Chat struct {
+	ID       int64
+	Name     string
+	Type     string
+	Messages []*ChatMessage
+}
+
+// ChatMessage is a message of a Chat. Files are the paths of the photos
+// and files sent with it, relative to the export's directory; they are
+// only there when the export included media.
+type ChatMessage struct {
+	ID            int64
+	Date          time.Time
+	From          string
+	Text          string
+	ForwardedFrom string
+	Files         []string
+}
+
+// IsChatFormat reports whether files with this extension hold chat
+// exports that must be read with ExtractChats.
+func IsChatFormat(ext string) bool {
+	return strings.ToLower(ext) == ".json"
+}
+
+type telegramExport struct {
+	telegramChat
+	Chats struct {
+		List []telegramChat `json:"list"`
+	} `json:"chats"`
+	LeftChats struct {
+		List []telegramChat `json:"list"`
+	} `json:"left_chats"`
+}
+
+type telegramChat struct {
+	ID       int64             `json:"id"`
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Messages []telegramMessage `json:"messages"`
+}
+
+type telegramMessage struct {
+	ID            int64        `json:"id"`
+	Type          string       `json:"type"`
+	Date          string       `json:"date"`
+	DateUnixtime  string       `json:"date_unixtime"`
+	From          string       `json:"from"`
+	ForwardedFrom string       `json:"forwarded_from"`
+	Text          telegramText `json:"text"`
+	Photo         string       `json:"photo"`
+	File          string       `json:"file"`
+}
+
+// telegramText is the text of a message: a string, or an array of
+// strings and formatted pieces such as {"type": "bold", "text": "..."}.
+type telegramText string
+
+func (t *telegramText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = telegramText(s)
+		return nil
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		var piece struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(part, &s); err == nil {
+			b.WriteString(s)
+		} else if err := json.Unmarshal(part, &piece); err == nil {
+			b.WriteString(piece.Text)
+		}
+	}
+	*t = telegramText(b.String())
+	return nil
+}
+
+// ExtractChats reads the chats of a Telegram Desktop result.json, either
+// the export of a single chat or of the whole account. Service messages,
+// such as members joining, are left out.
+func ExtractChats(content []byte) ([]*Chat, error) {
+	var export telegramExport
+	if err := json.Unmarshal(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")), &export); err != nil {
+		return nil, fmt.Errorf("failed to parse chat export: %v", err)
+	}
+
+	var sources []telegramChat
+	switch {
+	case export.Messages != nil:
+		sources = []telegramChat{export.telegramChat}
+	case export.Chats.List != nil || export.LeftChats.List != nil:
+		sources = append(export.Chats.List, export.LeftChats.List...)
+	default:
+		return nil, errors.New("not a Telegram chat export")
+	}
+
+	chats := make([]*Chat, 0, len(sources))
+	for _, src := range sources {
+		chat := &Chat{ID: src.ID, Name: src.Name, Type: src.Type}
+		if chat.Name == "" {
+			chat.Name = fmt.Sprintf("Chat %d", src.ID)
+		}
+		for _, m := range src.Messages {
+			if m.Type != "message" {
+				continue
+			}
+			msg := &ChatMessage{
+				ID:            m.ID,
+				Date:          telegramDate(m),
+				From:          m.From,
+				Text:          strings.TrimSpace(string(m.Text)),
+				ForwardedFrom: m.ForwardedFrom,
+			}
+			for _, file := range []string{m.Photo, m.File} {
+				// Media left out of the export are given as a note in
+				// parentheses instead of a path.
+				if file != "" && !strings.HasPrefix(file, "(") {
+					msg.Files = append(msg.Files, file)
+				}
+			}
+			chat.Messages = append(chat.Messages, msg)
+		}
+		chats = append(chats, chat)
+	}
+	return chats, nil
+}
+
+// telegramDate prefers the Unix time newer exports add, since date is
+// in the local time of whoever exported the chat.
+func telegramDate(m telegramMessage) time.Time {
+	if seconds, err := strconv.ParseInt(m.DateUnixtime, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC()
+	}
+	if date, err := time.ParseInLocation("2006-01-02T15:04:05", m.Date, time.Local); err == nil {
+		return date
+	}
+	return time.Time{}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shallowseek/batch"
+	"github.com/shallowseek/connectors"
+	"github.com/shallowseek/models"
+)
+
+// ListDeadLettersHandler lists the documents that failed indexing for
+// good, without their content.
+func ListDeadLettersHandler(c *gin.Context) {
+	letters, err := BatchProcessor.DeadLetters()
+	if err != nil {
+		log.Printf("[Admin] Error listing dead letters: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	items := make([]gin.H, 0, len(letters))
+	for _, dl := range letters {
+		items = append(items, gin.H{
+			"id":        dl.Document.ID,
+			"path":      dl.Document.Path,
+			"type":      dl.Document.Type,
+			"error":     dl.Error,
+			"status":    dl.Status,
+			"attempts":  dl.Attempts,
+			"failed_at": dl.FailedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"total": len(items), "dead_letters": items})
+}
+
+func GetDeadLetterHandler(c *gin.Context) {
+	dl, err := BatchProcessor.DeadLetter(c.Param("id"))
+	if err != nil {
+		deadLetterError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dl)
+}
+
+// FixDeadLetterHandler replaces the stored document with the one in the
+// request body. It is indexed again only when requeued.
+func FixDeadLetterHandler(c *gin.Context) {
+	var doc models.Document
+	if err := c.ShouldBindJSON(&doc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document: " + err.Error()})
+		return
+	}
+
+	dl, err := BatchProcessor.FixDeadLetter(c.Param("id"), doc)
+	if err != nil {
+		deadLetterError(c, err)
+		return
+	}
+	log.Printf("[Admin] Updated dead letter %s", dl.Document.ID)
+	c.JSON(http.StatusOK, dl)
+}
+
+func RequeueDeadLetterHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := BatchProcessor.RequeueDeadLetter(id); err != nil {
+		deadLetterError(c, err)
+		return
+	}
+	log.Printf("[Admin] Requeued dead letter %s", id)
+	c.JSON(http.StatusOK, gin.H{"message": "Document requeued for indexing", "id": id})
+}
+
+func DeleteDeadLetterHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := BatchProcessor.DeleteDeadLetter(id); err != nil {
+		deadLetterError(c, err)
+		return
+	}
+	log.Printf("[Admin] Deleted dead letter %s", id)
+	c.JSON(http.StatusOK, gin.H{"message": "Dead letter deleted", "id": id})
+}
+
+func deadLetterError(c *gin.Context, err error) {
+	if errors.Is(err, batch.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead letter not found"})
+		return
+	}
+	log.Printf("[Admin] Dead letter error: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func ImportStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, BatchProcessor.ImportStatus())
+}
+
+// StartImportHandler switches the index to bulk import mode until
+// FinishImportHandler is called.
+func StartImportHandler(c *gin.Context) {
+	if err := BatchProcessor.BeginImport(); err != nil {
+		log.Printf("[Admin] Error starting import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("[Admin] Bulk import started")
+	c.JSON(http.StatusOK, BatchProcessor.ImportStatus())
+}
+
+func FinishImportHandler(c *gin.Context) {
+	if err := BatchProcessor.EndImport(); err != nil {
+		if errors.Is(err, batch.ErrNoImport) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("[Admin] Error finishing import: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("[Admin] Bulk import finished")
+	c.JSON(http.StatusOK, BatchProcessor.ImportStatus())
+}
+
+// Connectors are the running connectors, registered at startup.
+var Connectors []connectors.Connector
+
+func ListConnectorsHandler(c *gin.Context) {
+	statuses := make([]connectors.Status, 0, len(Connectors))
+	for _, conn := range Connectors {
+		statuses = append(statuses, conn.Status())
+	}
+	c.JSON(http.StatusOK, gin.H{"connectors": statuses})
+}
+
+// SyncConnectorHandler starts a full sync of a connector, or queues one
+// if a sync is running.
+func SyncConnectorHandler(c *gin.Context) {
+	name := c.Param("name")
+	for _, conn := range Connectors {
+		if conn.Name() == name {
+			conn.Sync()
+			log.Printf("[Admin] Sync of connector %s requested", name)
+			c.JSON(http.StatusAccepted, gin.H{"message": "Sync requested", "connector": conn.Status()})
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Connector not found"})
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shallowseek/apikeys"
+	"github.com/shallowseek/config"
+	"github.com/shallowseek/metrics"
+)
+
+var APIKeys = apikeys.NewManager(config.GetAPIKeysFile(), config.GetBootstrapAPIKey())
+
+// anonymousKey is who requests made without a key are attributed to.
+var anonymousKey = apikeys.Key{
+	ID:     "anonymous",
+	Name:   "Anonymous",
+	Scopes: config.GetAnonymousScopes(),
+}
+
+type apiKeyContextKey struct{}
+
+// Authenticate identifies the key of a request, sent in the X-API-Key
+// header or as an Authorization bearer token, and logs the request
+// against it. Requests without a key are anonymous; a key that is
+// unknown, revoked or over its rate limit is turned away.
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := anonymousKey
+		if secret := requestAPIKey(c.Request); secret != "" {
+			var err error
+			key, err = APIKeys.Authenticate(secret)
+			switch {
+			case errors.Is(err, apikeys.ErrQuotaExceeded):
+				log.Printf("[Auth] Key %s over its rate limit for %s %s", key.ID, c.Request.Method, c.Request.URL.Path)
+				metrics.APIRequests.WithLabelValues(key.ID, strconv.Itoa(http.StatusTooManyRequests)).Inc()
+				c.Header("Retry-After", strconv.Itoa(60-time.Now().Second()))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+				return
+			case err != nil:
+				log.Printf("[Auth] Rejected key for %s %s from %s", c.Request.Method, c.Request.URL.Path, c.ClientIP())
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), apiKeyContextKey{}, key))
+		c.Next()
+
+		status := c.Writer.Status()
+		metrics.APIRequests.WithLabelValues(key.ID, strconv.Itoa(status)).Inc()
+		log.Printf("[API] %s %s %d by key %s (%s)", c.Request.Method, c.Request.URL.Path, status, key.ID, key.Name)
+	}
+}
+
+// RequireScope turns away requests whose key lacks a scope: with 401 if
+// they had no key, with 403 otherwise.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := requestKey(c.Request)
+		if key.Allows(scope) {
+			c.Next()
+			return
+		}
+		if key.ID == anonymousKey.ID {
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+	}
+}
+
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// requestKey returns the key a request was made with, the anonymous one
+// if none.
+func requestKey(r *http.Request) apikeys.Key {
+	if key, ok := r.Context().Value(apiKeyContextKey{}).(apikeys.Key); ok {
+		return key
+	}
+	return anonymousKey
+}
+
+func ListAPIKeysHandler(c *gin.Context) {
+	keys := APIKeys.List()
+	c.JSON(http.StatusOK, gin.H{"total": len(keys), "keys": keys})
+}
+
+// CreateAPIKeyHandler adds a key. The response is the only place the key
+// itself is shown.
+func CreateAPIKeyHandler(c *gin.Context) {
+	var req apikeys.Key
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key: " + err.Error()})
+		return
+	}
+
+	key, secret, err := APIKeys.Create(req)
+	if err != nil {
+		apiKeyError(c, err)
+		return
+	}
+	log.Printf("[Admin] Created API key %s (%s) with scopes %v by key %s", key.ID, key.Name, key.Scopes, requestKey(c.Request).ID)
+	c.JSON(http.StatusCreated, gin.H{"key": secret, "api_key": key})
+}
+
+func GetAPIKeyHandler(c *gin.Context) {
+	key, err := APIKeys.Get(c.Param("id"))
+	if err != nil {
+		apiKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
+
+// RevokeAPIKeyHandler stops a key from being accepted. It stays listed.
+func RevokeAPIKeyHandler(c *gin.Context) {
+	key, err := APIKeys.Revoke(c.Param("id"))
+	if err != nil {
+		apiKeyError(c, err)
+		return
+	}
+	log.Printf("[Admin] Revoked API key %s (%s) by key %s", key.ID, key.Name, requestKey(c.Request).ID)
+	c.JSON(http.StatusOK, key)
+}
+
+func apiKeyError(c *gin.Context, err error) {
+	if errors.Is(err, apikeys.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if errors.Is(err, apikeys.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("[Admin] API key error: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shallowseek/cache"
+)
+
+const (
+	defaultPrewarmTop = 100
+	maxPrewarmTop     = 1000
+	defaultPrewarmAge = 24 * time.Hour
+)
+
+// CacheStatsHandler shows the hits, misses, errors and evictions of each
+// cache tier since the server started.
+func CacheStatsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, cache.GetStats())
+}
+
+// FlushCacheHandler deletes cached search results: all of them, or with
+// a "pattern" in the body, those of the queries it matches, such as
+// "*Отчёт*". The pattern is normalized like queries are.
+func FlushCacheHandler(c *gin.Context) {
+	var req struct {
+		Pattern string `json:"pattern"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	req.Pattern, _ = normalizeSearchQuery(req.Pattern, nil)
+	deleted, err := cache.Flush(req.Pattern)
+	if err != nil {
+		log.Printf("[Admin] Error flushing cache: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "deleted": deleted})
+		return
+	}
+	log.Printf("[Admin] Flushed %d cached results matching %q", deleted, req.Pattern)
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted, "pattern": req.Pattern})
+}
+
+// PrewarmCacheHandler runs the searches made most often according to
+// the query log, so their results are cached. "top" is how many, 100 by
+// default, and "since" how far back the log is read, 24h by default.
+func PrewarmCacheHandler(c *gin.Context) {
+	var req struct {
+		Top   int    `json:"top"`
+		Since string `json:"since"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+	if req.Top <= 0 {
+		req.Top = defaultPrewarmTop
+	}
+	if req.Top > maxPrewarmTop {
+		req.Top = maxPrewarmTop
+	}
+	age := defaultPrewarmAge
+	if req.Since != "" {
+		var err error
+		if age, err = time.ParseDuration(req.Since); err != nil || age <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since duration: " + req.Since})
+			return
+		}
+	}
+
+	top, err := QueryLog.Top(req.Top, time.Now().Add(-age))
+	if err != nil {
+		log.Printf("[Admin] Error reading query log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	warmed, cached, failed := 0, 0, 0
+	for _, q := range top {
+		values, err := url.ParseQuery(q.Params)
+		if err != nil {
+			failed++
+			continue
+		}
+		values.Set("q", q.Query)
+		search, err := parseSearchRequest(values)
+		if err != nil {
+			failed++
+			continue
+		}
+		_, status, err := runSearch(search)
+		switch {
+		case err != nil:
+			log.Printf("[Admin] Error pre-warming query %s: %v", q.Query, err)
+			failed++
+		case status == "HIT":
+			cached++
+		default:
+			warmed++
+		}
+	}
+	log.Printf("[Admin] Pre-warmed cache: %d searched, %d already cached, %d failed", warmed, cached, failed)
+	c.JSON(http.StatusOK, gin.H{
+		"queries": len(top),
+		"warmed":  warmed,
+		"cached":  cached,
+		"failed":  failed,
+	})
+}
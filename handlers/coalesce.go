@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/shallowseek/models"
+)
+
+// searchFlights coalesces identical searches.
+var searchFlights = &flightGroup{}
+
+// flightGroup runs one call at a time per key: callers asking for a key
+// whose call is in flight wait for it and share its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done   chan struct{}
+	result models.SimplifiedSearchResult
+	err    error
+}
+
+// do runs fn for key unless a call for key is in flight, and returns its
+// result and whether it came from a call made for another caller.
+func (g *flightGroup) do(key string, fn func() (models.SimplifiedSearchResult, error)) (models.SimplifiedSearchResult, error, bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.result, call.err, true
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	call := &flight{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		// Callers waiting on a call that panicked get an error rather than
+		// empty results; the panic goes on in the caller that made it.
+		r := recover()
+		if r != nil {
+			call.result = models.SimplifiedSearchResult{}
+			call.err = fmt.Errorf("search failed: %v", r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	call.result, call.err = fn()
+	return call.result, call.err, false
+}
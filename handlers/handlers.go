@@ -4,13 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
-	"os"
-	"os/exec"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -23,12 +25,17 @@ import (
 	"github.com/shallowseek/cache"
 	"github.com/shallowseek/config"
 	"github.com/shallowseek/elasticsearch"
+	"github.com/shallowseek/ingest"
 	"github.com/shallowseek/metrics"
 	"github.com/shallowseek/models"
+	"github.com/shallowseek/querylog"
+	"github.com/shallowseek/webhooks"
 )
 
 var (
 	BatchProcessor = batch.NewBatchProcessor()
+	QueryLog       = querylog.New(config.GetQueryLogFile(), config.GetQueryLogMaxBytes())
+	extractSlots   = make(chan struct{}, config.GetExtractWorkers())
 )
 
 func init() {
@@ -36,47 +43,205 @@ func init() {
 }
 
 func SearchHandler(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
+	start := time.Now()
+	req, err := parseSearchRequest(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("[Search] Processing search request for query: %s", query)
+	log.Printf("[Search] Processing search request for query: %s", req.query)
 
-	if cachedResults, err := cache.GetCachedSearchResult(query); err == nil && cachedResults != nil {
-		log.Printf("[Search] Cache hit for query: %s", query)
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Cache", "HIT")
-		json.NewEncoder(w).Encode(cachedResults)
+	result, cacheStatus, err := runSearch(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("[Search] Cache miss for query: %s", query)
+	QueryLog.Record(querylog.Entry{
+		Time:       start,
+		Key:        requestKey(r).ID,
+		Query:      req.query,
+		Params:     req.params,
+		Results:    len(result.Results),
+		DurationMs: time.Since(start).Milliseconds(),
+		Cache:      cacheStatus,
+	})
 
-	var buf bytes.Buffer
-	searchQuery := map[string]interface{}{
-		"query": map[string]interface{}{
-			"bool": map[string]interface{}{
-				"should": []map[string]interface{}{
-					{
-						"match_phrase": map[string]interface{}{
-							"content": map[string]interface{}{
-								"query": query,
-								"slop":  0,
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Cache", cacheStatus)
+	json.NewEncoder(w).Encode(result)
+}
+
+// searchRequest is a parsed search. query is its normalized form, and
+// params the other parameters that affect results.
+type searchRequest struct {
+	text         string
+	filters      map[string]string
+	sortClause   []interface{}
+	rangeClauses []map[string]interface{}
+	query        string
+	params       string
+}
+
+// parseSearchRequest reads a search from the parameters of the search
+// API. Its errors are the client's.
+func parseSearchRequest(values url.Values) (searchRequest, error) {
+	q := values.Get("q")
+	if q == "" {
+		return searchRequest{}, errors.New("Query parameter 'q' is required")
+	}
+
+	sortClause, err := parseSearchSort(values.Get("sort"))
+	if err != nil {
+		return searchRequest{}, err
+	}
+	rangeClauses, err := parseSearchRanges(values)
+	if err != nil {
+		return searchRequest{}, err
+	}
+
+	text, filters := normalizeSearchQuery(parseSearchQuery(q))
+	if text == "" && len(filters) == 0 {
+		return searchRequest{}, errors.New("Query parameter 'q' is required")
+	}
+
+	// Variants of a query in case, spacing and filter order are the same
+	// search.
+	return searchRequest{
+		text:         text,
+		filters:      filters,
+		sortClause:   sortClause,
+		rangeClauses: rangeClauses,
+		query:        searchQueryKey(text, filters),
+		params:       searchParamsKey(values),
+	}, nil
+}
+
+// runSearch returns the results of a search from the cache or, failing
+// that, Elasticsearch, caching them. It also returns where they came
+// from: HIT, COALESCED or MISS.
+func runSearch(req searchRequest) (models.SimplifiedSearchResult, string, error) {
+	// Sorting and ranges change the results, so they are part of the key,
+	// after a "|" that is always there so the query can be told apart.
+	cacheKey := req.query + "|" + req.params
+
+	// The generation is read before searching, so the results of a search
+	// that raced a write are cached under a generation no one reads again.
+	generation := cache.Generation()
+	if cachedResults, err := cache.GetCachedSearchResult(generation, cacheKey); err == nil && cachedResults != nil {
+		log.Printf("[Search] Cache hit for query: %s", req.query)
+		return *cachedResults, "HIT", nil
+	}
+
+	log.Printf("[Search] Cache miss for query: %s", req.query)
+
+	// Identical searches arriving while one is running wait for its
+	// results instead of each querying Elasticsearch.
+	result, err, shared := searchFlights.do(generation+"|"+cacheKey, func() (models.SimplifiedSearchResult, error) {
+		result, err := executeSearch(req.text, req.filters, req.sortClause, req.rangeClauses)
+		if err != nil {
+			return result, err
+		}
+		if err := cache.CacheSearchResult(generation, cacheKey, result); err != nil {
+			log.Printf("[Search] Failed to cache search results: %v", err)
+		}
+		log.Printf("[Search] Search completed in %dms with %d results", result.Duration, len(result.Results))
+		return result, nil
+	})
+	if err != nil {
+		return result, "", err
+	}
+	if shared {
+		return result, "COALESCED", nil
+	}
+	return result, "MISS", nil
+}
+
+// executeSearch runs a parsed search against Elasticsearch.
+func executeSearch(text string, filters map[string]string, sortClause []interface{}, rangeClauses []map[string]interface{}) (models.SimplifiedSearchResult, error) {
+	boolQuery := map[string]interface{}{}
+	if text != "" {
+		// Documents are scored by their best passage. The match on the
+		// whole content only helps documents whose terms are spread over
+		// several passages, and those indexed before passages existed.
+		boolQuery["should"] = []map[string]interface{}{
+			{
+				"nested": map[string]interface{}{
+					"path": "passages",
+					"query": map[string]interface{}{
+						"bool": map[string]interface{}{
+							"should": []map[string]interface{}{
+								{
+									"match_phrase": map[string]interface{}{
+										"passages.text": map[string]interface{}{
+											"query": text,
+											"slop":  0,
+											"boost": 2,
+										},
+									},
+								},
+								{
+									"match": map[string]interface{}{
+										"passages.text": map[string]interface{}{
+											"query":                text,
+											"minimum_should_match": "75%",
+										},
+									},
+								},
 							},
+							"minimum_should_match": 1,
 						},
 					},
-					{
-						"multi_match": map[string]interface{}{
-							"query":  query,
-							"fields": []string{"content"},
-							"type":   "best_fields",
-							"minimum_should_match": "75%",
+					"score_mode": "max",
+					"inner_hits": map[string]interface{}{
+						"size":    3,
+						"_source": []string{"passages.location", "passages.sheet", "passages.row", "passages.column", "passages.slide", "passages.page",
+							"passages.start", "passages.end"},
+						"highlight": map[string]interface{}{
+							"fields": map[string]interface{}{
+								"passages.text": map[string]interface{}{
+									"fragment_size":       200,
+									"number_of_fragments": 1,
+									"pre_tags":            []string{"<mark>"},
+									"post_tags":           []string{"</mark>"},
+								},
+							},
 						},
 					},
 				},
 			},
+			{
+				"multi_match": map[string]interface{}{
+					"query":  text,
+					"fields": []string{"content"},
+					"type":   "best_fields",
+					"minimum_should_match": "75%",
+					"boost":  0.2,
+				},
+			},
+		}
+		boolQuery["minimum_should_match"] = 1
+	}
+	if len(filters) > 0 || len(rangeClauses) > 0 {
+		filterClauses := rangeClauses
+		for field, value := range filters {
+			filterClauses = append(filterClauses, map[string]interface{}{
+				"match": map[string]interface{}{
+					field: map[string]interface{}{
+						"query":    value,
+						"operator": "and",
+					},
+				},
+			})
+		}
+		boolQuery["filter"] = filterClauses
+	}
+
+	var buf bytes.Buffer
+	searchQuery := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": boolQuery,
 		},
 		"highlight": map[string]interface{}{
 			"fields": map[string]interface{}{
@@ -88,13 +253,17 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 				},
 			},
 		},
-		"_source": []string{"id", "path", "type", "content", "indexed"},
+		"_source": []string{"id", "path", "type", "indexed", "from", "to", "subject", "chat", "date", "parent_id",
+			"title", "author", "created", "modified", "page_count", "language", "producer"},
+	}
+	if sortClause != nil {
+		searchQuery["sort"] = sortClause
+		searchQuery["track_scores"] = true
 	}
 
 	if err := json.NewEncoder(&buf).Encode(searchQuery); err != nil {
 		log.Printf("[Search] Error encoding search query: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return models.SimplifiedSearchResult{}, err
 	}
 
 	log.Printf("[Search] Executing search with query: %s", buf.String())
@@ -108,22 +277,19 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 	)
 	if err != nil {
 		log.Printf("[Search] Error executing search: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return models.SimplifiedSearchResult{}, err
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
 		log.Printf("[Search] Elasticsearch error: %s", res.String())
-		http.Error(w, fmt.Sprintf("Error searching documents: %s", res.String()), http.StatusInternalServerError)
-		return
+		return models.SimplifiedSearchResult{}, fmt.Errorf("Error searching documents: %s", res.String())
 	}
 
 	var rawResult map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&rawResult); err != nil {
 		log.Printf("[Search] Error decoding response: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return models.SimplifiedSearchResult{}, err
 	}
 
 	simplifiedResult := models.SimplifiedSearchResult{
@@ -160,8 +326,30 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 				indexedStr, _ := sourceMap["indexed"].(string)
 				indexed, _ := time.Parse(time.RFC3339, indexedStr)
 
+				from, _ := sourceMap["from"].(string)
+				subject, _ := sourceMap["subject"].(string)
+				chat, _ := sourceMap["chat"].(string)
+				parentID, _ := sourceMap["parent_id"].(string)
+				date := sourceTime(sourceMap, "date")
+
+				var metadata models.Metadata
+				metadata.Title, _ = sourceMap["title"].(string)
+				metadata.Author, _ = sourceMap["author"].(string)
+				metadata.Language, _ = sourceMap["language"].(string)
+				metadata.Producer, _ = sourceMap["producer"].(string)
+				metadata.Created = sourceTime(sourceMap, "created")
+				metadata.Modified = sourceTime(sourceMap, "modified")
+				if pageCount, ok := sourceMap["page_count"].(float64); ok {
+					metadata.PageCount = int(pageCount)
+				}
+
+				passages := passageHits(hitMap)
+
 				var snippets []string
-				if highlightMap, ok := hitMap["highlight"].(map[string]interface{}); ok {
+				for _, passage := range passages {
+					snippets = append(snippets, passage.Location+": "+passage.Snippet)
+				}
+				if highlightMap, ok := hitMap["highlight"].(map[string]interface{}); ok && len(snippets) == 0 {
 					if contentHighlights, ok := highlightMap["content"].([]interface{}); ok {
 						for _, highlight := range contentHighlights {
 							if snippet, ok := highlight.(string); ok {
@@ -182,6 +370,10 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 					}
 				}
 
+				if len(snippets) == 0 && subject != "" {
+					snippets = append(snippets, "Subject: "+subject)
+				}
+
 				if len(snippets) == 0 {
 					if strings.HasSuffix(strings.ToLower(docType), "pdf") {
 						snippets = append(snippets, "PDF document contains matching content")
@@ -193,6 +385,21 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 				downloadURL := fmt.Sprintf("/api/documents/%s/download", id)
 				viewURL := fmt.Sprintf("/api/documents/%s/view", id)
 
+				// Open paged documents at the page of each passage; the
+				// result link goes to the best one.
+				for i := range passages {
+					if passages[i].Page > 0 {
+						passages[i].ViewURL = fmt.Sprintf("%s#page=%d", viewURL, passages[i].Page)
+					}
+				}
+				if len(passages) > 0 && passages[0].ViewURL != "" {
+					viewURL = passages[0].ViewURL
+				}
+				// Crawled pages are viewed where they were found.
+				if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+					viewURL = path
+				}
+
 				simplifiedResult.Results = append(simplifiedResult.Results, models.SimplifiedDocument{
 					ID:          id,
 					Path:        path,
@@ -200,6 +407,13 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 					Indexed:     indexed,
 					Score:       score,
 					Snippets:    snippets,
+					Passages:    passages,
+					From:        from,
+					Subject:     subject,
+					Date:        date,
+					Chat:        chat,
+					ParentID:    parentID,
+					Metadata:    metadata,
 					DownloadURL: downloadURL,
 					ViewURL:     viewURL,
 				})
@@ -207,15 +421,84 @@ func SearchHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := cache.CacheSearchResult(query, simplifiedResult); err != nil {
-		log.Printf("[Search] Failed to cache search results: %v", err)
+	return simplifiedResult, nil
+}
+
+// sourceTime reads an optional date field of a hit's _source.
+func sourceTime(sourceMap map[string]interface{}, field string) *time.Time {
+	value, ok := sourceMap[field].(string)
+	if !ok {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+// passageHits reads the best matching passages of a search hit from
+// its nested inner hits.
+func passageHits(hitMap map[string]interface{}) []models.PassageHit {
+	innerHits, ok := hitMap["inner_hits"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	passagesResult, ok := innerHits["passages"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	hits, ok := passagesResult["hits"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	hitsArray, ok := hits["hits"].([]interface{})
+	if !ok {
+		return nil
 	}
 
-	log.Printf("[Search] Search completed in %dms with %d results", simplifiedResult.Duration, len(simplifiedResult.Results))
+	var passages []models.PassageHit
+	for _, hit := range hitsArray {
+		innerMap, ok := hit.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		highlightMap, ok := innerMap["highlight"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		highlights, ok := highlightMap["passages.text"].([]interface{})
+		if !ok || len(highlights) == 0 {
+			continue
+		}
+		snippet, ok := highlights[0].(string)
+		if !ok || isBinaryContent(snippet) {
+			continue
+		}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Cache", "MISS")
-	json.NewEncoder(w).Encode(simplifiedResult)
+		source, _ := innerMap["_source"].(map[string]interface{})
+		passage := models.PassageHit{Snippet: snippet}
+		passage.Location, _ = source["location"].(string)
+		passage.Sheet, _ = source["sheet"].(string)
+		passage.Column, _ = source["column"].(string)
+		if row, ok := source["row"].(float64); ok {
+			passage.Row = int(row)
+		}
+		if slide, ok := source["slide"].(float64); ok {
+			passage.Slide = int(slide)
+		}
+		if page, ok := source["page"].(float64); ok {
+			passage.Page = int(page)
+		}
+		if start, ok := source["start"].(float64); ok {
+			passage.Start = int(start)
+		}
+		if end, ok := source["end"].(float64); ok {
+			passage.End = int(end)
+		}
+		passages = append(passages, passage)
+	}
+	return passages
 }
 
 func isBinaryContent(text string) bool {
@@ -306,13 +589,7 @@ func UploadFileHandler(c *gin.Context) {
 	}
 
 	ext := strings.ToLower(filepath.Ext(file.Filename))
-	supportedTypes := map[string]bool{
-		".txt":  true,
-		".pdf":  true,
-		".doc":  true,
-		".docx": true,
-	}
-	if !supportedTypes[ext] {
+	if !ingest.IsSupported(ext) {
 		log.Printf("[Upload] Unsupported file type: %s", ext)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported file type"})
 		return
@@ -341,63 +618,67 @@ func UploadFileHandler(c *gin.Context) {
 		return
 	}
 
-	var contentStr string
-	if ext == ".pdf" {
-		tmpFile, err := os.CreateTemp("", "upload-*.pdf")
-		if err != nil {
-			log.Printf("[Upload] Error creating temp file: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process PDF"})
-			return
-		}
-		defer os.Remove(tmpFile.Name())
-		defer tmpFile.Close()
+	// Don't spend time extracting a file the queue has no room for.
+	if BatchProcessor.Full() {
+		queueFull(c)
+		return
+	}
 
-		if _, err := tmpFile.Write(content); err != nil {
-			log.Printf("[Upload] Error writing to temp file: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process PDF"})
-			return
-		}
+	job := JobTracker.Create(file.Filename)
+	log.Printf("[Upload] Created job %s for %s", job.ID, file.Filename)
 
-		cmd := exec.Command("pdftotext", tmpFile.Name(), "-")
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		if err := cmd.Run(); err != nil {
-			log.Printf("[Upload] Error extracting text from PDF: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract text from PDF"})
+	// Extraction (OCR especially) is expensive, so only a few uploads
+	// extract at once; the others wait here as queued jobs.
+	extractSlots <- struct{}{}
+	JobTracker.Extracting(job.ID)
+	docs, err := ingest.Documents(file.Filename, content)
+	<-extractSlots
+	if err != nil {
+		log.Printf("[Upload] Error extracting text: %v", err)
+		JobTracker.Fail(job.ID, err)
+		Webhooks.Emit(webhooks.ExtractionFailed, map[string]interface{}{
+			"job_id":   job.ID,
+			"filename": file.Filename,
+			"type":     ext,
+			"error":    err.Error(),
+		})
+		if errors.Is(err, ingest.ErrNoMessages) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "job_id": job.ID})
 			return
 		}
-
-		contentStr = out.String()
-		if contentStr == "" {
-			log.Printf("[Upload] Warning: No text extracted from PDF")
-			contentStr = "PDF document (no text content extracted)"
-		}
-		log.Printf("[Upload] Extracted %d bytes of text from PDF", len(contentStr))
-	} else {
-		contentStr = string(content)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to extract text from file", "job_id": job.ID})
+		return
 	}
-
-	doc := models.Document{
-		ID:        models.GenerateID(),
-		Path:      file.Filename,
-		Type:      ext,
-		Content:   contentStr,
-		Indexed:   time.Now(),
+	if len(docs) == 0 {
+		log.Printf("[Upload] Nothing to index in %s", file.Filename)
+		JobTracker.Fail(job.ID, errors.New("nothing to index"))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to index in file", "job_id": job.ID})
+		return
 	}
 
-	if ext == ".pdf" {
-		doc.OriginalContent = base64.StdEncoding.EncodeToString(content)
+	docIDs := make([]string, 0, len(docs))
+	for i := range docs {
+		docs[i].Source = "upload"
+		docIDs = append(docIDs, docs[i].ID)
 	}
+	JobTracker.Track(job.ID, docIDs)
 
-	log.Printf("[Upload] Created document with ID: %s", doc.ID)
-
-	if err := BatchProcessor.AddDocument(doc); err != nil {
-		log.Printf("[Upload] Error adding document to batch: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to queue document for indexing: %v", err)})
+	for _, doc := range docs {
+		log.Printf("[Upload] Created document with ID: %s", doc.ID)
+	}
+	if err := BatchProcessor.AddDocuments(docs); err != nil {
+		log.Printf("[Upload] Error adding documents to batch: %v", err)
+		JobTracker.Fail(job.ID, err)
+		if errors.Is(err, batch.ErrQueueFull) {
+			queueFull(c)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to queue document for indexing: %v", err), "job_id": job.ID})
 		return
 	}
+	doc := docs[0]
 	
-	log.Printf("[Upload] Successfully queued document for indexing: %s", doc.ID)
+	log.Printf("[Upload] Successfully queued %d document(s) for indexing: %s", len(docIDs), doc.ID)
 	
 	response := gin.H{
 		"message":     "File uploaded and queued for indexing",
@@ -407,6 +688,12 @@ func UploadFileHandler(c *gin.Context) {
 		"type":        ext,
 		"download_url": fmt.Sprintf("/api/documents/%s/download", doc.ID),
 		"view_url":    fmt.Sprintf("/api/documents/%s/view", doc.ID),
+		"job_id":      job.ID,
+		"job_url":     fmt.Sprintf("/api/jobs/%s", job.ID),
+		"events_url":  fmt.Sprintf("/api/jobs/%s/events", job.ID),
+	}
+	if len(docIDs) > 1 {
+		response["document_ids"] = docIDs
 	}
 	
 	log.Printf("[Upload] Sending response: %+v", response)
@@ -461,12 +748,15 @@ func DownloadDocumentHandler(c *gin.Context) {
 	}
 
 	var content []byte
-	if strings.ToLower(result.Source.Type) == ".pdf" {
-		if result.Source.OriginalContent != "" {
-			content, err = base64.StdEncoding.DecodeString(result.Source.OriginalContent)
-		} else {
-			content, err = base64.StdEncoding.DecodeString(result.Source.Content)
+	if result.Source.OriginalContent != "" {
+		content, err = base64.StdEncoding.DecodeString(result.Source.OriginalContent)
+		if err != nil {
+			log.Printf("[Download] Error decoding original content: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding original content"})
+			return
 		}
+	} else if strings.ToLower(result.Source.Type) == ".pdf" {
+		content, err = base64.StdEncoding.DecodeString(result.Source.Content)
 		if err != nil {
 			log.Printf("[Download] Error decoding PDF content: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding PDF content"})
@@ -478,6 +768,9 @@ func DownloadDocumentHandler(c *gin.Context) {
 	
 	fileName := filepath.Base(result.Source.Path)
 	contentType := "text/plain"
+	if result.Source.Encoding != "" && result.Source.OriginalContent != "" {
+		contentType = "text/plain; charset=" + result.Source.Encoding
+	}
 	
 	switch strings.ToLower(result.Source.Type) {
 	case ".pdf":
@@ -486,6 +779,32 @@ func DownloadDocumentHandler(c *gin.Context) {
 		contentType = "application/msword"
 	case ".docx":
 		contentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case ".png", ".jpg", ".jpeg", ".tif", ".tiff":
+		contentType = imageContentType(result.Source.Type)
+	case ".odt":
+		contentType = "application/vnd.oasis.opendocument.text"
+	case ".rtf":
+		contentType = "application/rtf"
+	case ".html", ".htm":
+		contentType = textContentType("text/html", result.Source.Encoding)
+	case ".md", ".markdown":
+		contentType = textContentType("text/markdown", result.Source.Encoding)
+	case ".epub":
+		contentType = "application/epub+zip"
+	case ".xlsx":
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case ".ods":
+		contentType = "application/vnd.oasis.opendocument.spreadsheet"
+	case ".csv":
+		contentType = textContentType("text/csv", result.Source.Encoding)
+	case ".pptx":
+		contentType = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	case ".eml":
+		contentType = "message/rfc822"
+	case ".mbox":
+		contentType = "application/mbox"
+	case ".msg":
+		contentType = "application/vnd.ms-outlook"
 	}
 	
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
@@ -554,23 +873,163 @@ func ViewDocumentHandler(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding PDF content"})
 			return
 		}
+	} else if imageType := imageContentType(result.Source.Type); imageType != "" {
+		content, err = base64.StdEncoding.DecodeString(result.Source.OriginalContent)
+		if err != nil {
+			log.Printf("[View] Error decoding image content: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding image content"})
+			return
+		}
+	} else if isMarkupType(result.Source.Type) && result.Source.OriginalContent != "" {
+		content, err = base64.StdEncoding.DecodeString(result.Source.OriginalContent)
+		if err != nil {
+			log.Printf("[View] Error decoding original content: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding original content"})
+			return
+		}
 	} else {
 		content = []byte(result.Source.Content)
 	}
 	
 	switch strings.ToLower(result.Source.Type) {
-	case ".txt":
+	case ".txt", ".eml", ".mbox", ".msg":
 		c.Header("Content-Type", "text/plain; charset=utf-8")
 		c.Data(http.StatusOK, "text/plain; charset=utf-8", content)
 	case ".pdf":
 		c.Header("Content-Type", "application/pdf")
 		c.Header("Content-Disposition", "inline; filename="+filepath.Base(result.Source.Path))
 		c.Data(http.StatusOK, "application/pdf", content)
-	case ".doc", ".docx":
+	case ".png", ".jpg", ".jpeg", ".tif", ".tiff":
+		imageType := imageContentType(result.Source.Type)
+		c.Header("Content-Type", imageType)
+		c.Header("Content-Disposition", "inline; filename="+filepath.Base(result.Source.Path))
+		c.Data(http.StatusOK, imageType, content)
+	case ".html", ".htm":
+		// Uploaded pages are rendered sandboxed so their scripts never
+		// run on our origin.
+		htmlType := textContentType("text/html", result.Source.Encoding)
+		c.Header("Content-Type", htmlType)
+		c.Header("Content-Security-Policy", "sandbox")
+		c.Data(http.StatusOK, htmlType, content)
+	case ".md", ".markdown":
+		markdownType := textContentType("text/markdown", result.Source.Encoding)
+		c.Header("Content-Type", markdownType)
+		c.Data(http.StatusOK, markdownType, content)
+	case ".doc", ".docx", ".odt", ".rtf", ".epub", ".xlsx", ".ods", ".csv", ".pptx":
 		c.Redirect(http.StatusSeeOther, fmt.Sprintf("/api/documents/%s/download", docID))
 	default:
 		c.Redirect(http.StatusSeeOther, fmt.Sprintf("/api/documents/%s/download", docID))
 	}
 	
 	log.Printf("[View] Successfully processed view request for document: %s", docID)
-} 
\ No newline at end of file
+}
+
+// maxDeleteDepth bounds how many levels of attachments are deleted along
+// with a document.
+const maxDeleteDepth = 10
+
+// DeleteDocumentHandler deletes a document from the index together with
+// the documents extracted from it, such as email attachments.
+func DeleteDocumentHandler(c *gin.Context) {
+	docID := c.Param("id")
+
+	log.Printf("[Delete] Processing delete request for document: %s", docID)
+
+	deleted, err := deleteDocuments([]string{docID})
+	if err != nil {
+		log.Printf("[Delete] Error deleting document %s: %v", docID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting document: " + err.Error()})
+		return
+	}
+	if len(deleted) == 0 {
+		log.Printf("[Delete] Document not found: %s", docID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	log.Printf("[Delete] Deleted document %s (%d documents in all)", docID, len(deleted))
+	c.JSON(http.StatusOK, gin.H{"message": "Document deleted", "id": docID, "deleted": deleted})
+}
+
+// deleteDocuments deletes documents and their attachments, and returns
+// the IDs of those that existed.
+func deleteDocuments(ids []string) ([]string, error) {
+	all := append([]string(nil), ids...)
+	level := ids
+	for depth := 0; depth < maxDeleteDepth && len(level) > 0; depth++ {
+		children, err := elasticsearch.ChildDocumentIDs(level)
+		if err != nil {
+			return nil, fmt.Errorf("error finding attachments: %v", err)
+		}
+		all = append(all, children...)
+		level = children
+	}
+
+	// A bulk import turns refreshing off, so waiting for a refresh would
+	// wait for the import to finish. Its end refreshes the index and
+	// moves the cache generation on, which makes the deletion visible.
+	refresh := "wait_for"
+	if BatchProcessor.ImportStatus().Importing {
+		refresh = "false"
+	}
+	deleted, err := elasticsearch.DeleteDocuments(all, refresh)
+	// Even a failed request may have deleted some of them.
+	cache.BumpGeneration()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range deleted {
+		Webhooks.Emit(webhooks.DocumentDeleted, map[string]interface{}{"document_id": id})
+	}
+	return deleted, nil
+}
+
+// ConnectorIndex is where the connectors send documents and deletions.
+var ConnectorIndex = connectorIndex{}
+
+type connectorIndex struct{}
+
+func (connectorIndex) AddDocuments(docs []models.Document) error {
+	return BatchProcessor.AddDocuments(docs)
+}
+
+func (connectorIndex) DeleteDocuments(ids []string) error {
+	_, err := deleteDocuments(ids)
+	return err
+}
+
+// queueFull turns an upload away while the indexing queue is full,
+// telling the client when to try again.
+func queueFull(c *gin.Context) {
+	retryAfter := int(math.Ceil(BatchProcessor.RetryAfter().Seconds()))
+	log.Printf("[Upload] Indexing queue is full, rejecting upload")
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Indexing queue is full, try again later"})
+}
+
+func imageContentType(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".tif", ".tiff":
+		return "image/tiff"
+	}
+	return ""
+}
+
+func isMarkupType(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".html", ".htm", ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+func textContentType(mimeType, encoding string) string {
+	if encoding == "" {
+		encoding = "utf-8"
+	}
+	return mimeType + "; charset=" + encoding
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shallowseek/jobs"
+	"github.com/shallowseek/models"
+)
+
+var JobTracker = jobs.NewTracker()
+
+// sseKeepAlive is how often an idle event stream sends a comment, so
+// proxies don't close it.
+const sseKeepAlive = 15 * time.Second
+
+func init() {
+	BatchProcessor.AddListener(func(doc models.Document, result string, err error) {
+		JobTracker.DocumentDone(doc.ID, err)
+	})
+}
+
+func GetJobHandler(c *gin.Context) {
+	job, ok := JobTracker.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// JobEventsHandler streams the state of a job as Server-Sent Events,
+// starting with its current state, until it is indexed or failed.
+func JobEventsHandler(c *gin.Context) {
+	id := c.Param("id")
+	updates, unsubscribe := JobTracker.Subscribe(id)
+	defer unsubscribe()
+
+	// Subscribe before reading the current state so no change is missed
+	// in between.
+	job, ok := JobTracker.Get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	if err := writeJobEvent(c, job); err != nil {
+		return
+	}
+	for !job.Finished() {
+		select {
+		case job = <-updates:
+			if err := writeJobEvent(c, job); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJobEvent(c *gin.Context, job jobs.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "event: job\ndata: %s\n\n", data); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchFilterFields maps the field prefixes accepted in a query string
+// to the indexed fields they filter on.
+var searchFilterFields = map[string]string{
+	"from":     "from",
+	"subject":  "subject",
+	"chat":     "chat",
+	"title":    "title",
+	"author":   "author",
+	"lang":     "language",
+	"language": "language",
+	"producer": "producer",
+}
+
+// exactFilterFields are the filter fields matched exactly rather than
+// through an analyzer, whose values keep their case.
+var exactFilterFields = map[string]bool{
+	"language": true,
+	"producer": true,
+}
+
+// searchSortFields maps the values accepted in the sort parameter to
+// the indexed fields they sort on. A leading "-" sorts descending.
+var searchSortFields = map[string]string{
+	"created":  "created",
+	"modified": "modified",
+	"indexed":  "indexed",
+	"date":     "date",
+	"title":    "title.keyword",
+	"author":   "author.keyword",
+	"pages":    "page_count",
+}
+
+// searchRangeParams maps the date range parameters of the search API to
+// the indexed field and range operator they apply.
+var searchRangeParams = map[string][2]string{
+	"created_from":  {"created", "gte"},
+	"created_to":    {"created", "lte"},
+	"modified_from": {"modified", "gte"},
+	"modified_to":   {"modified", "lte"},
+}
+
+// parseSearchQuery splits a query such as
+//
+//	from:ivanov subject:"годовой отчёт" бюджет
+//
+// into its free text ("бюджет") and its field filters. Values may be
+// quoted to include spaces. Unknown prefixes stay part of the text.
+func parseSearchQuery(q string) (string, map[string]string) {
+	filters := map[string]string{}
+	var text []string
+
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		if i >= len(runes) {
+			break
+		}
+
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != ':' && runes[i] != '"' {
+			i++
+		}
+		field, isFilter := searchFilterFields[strings.ToLower(string(runes[start:i]))]
+		if !isFilter || i >= len(runes) || runes[i] != ':' {
+			i = start
+			text = append(text, readQueryToken(runes, &i))
+			continue
+		}
+
+		i++
+		if value := readQueryToken(runes, &i); value != "" {
+			filters[field] = value
+		}
+	}
+
+	return strings.Join(text, " "), filters
+}
+
+// normalizeSearchQuery collapses the spacing of a parsed query and,
+// since analyzed fields ignore case, lowercases all but the values of
+// exact filters. Variants of a query then search the same way.
+func normalizeSearchQuery(text string, filters map[string]string) (string, map[string]string) {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	normalized := make(map[string]string, len(filters))
+	for field, value := range filters {
+		value = strings.Join(strings.Fields(value), " ")
+		if !exactFilterFields[field] {
+			value = strings.ToLower(value)
+		}
+		if value != "" {
+			normalized[field] = value
+		}
+	}
+	return text, normalized
+}
+
+// searchQueryKey renders a normalized query with its filters in a
+// stable order, for use in cache keys and the query log. It parses back
+// into the same query.
+func searchQueryKey(text string, filters map[string]string) string {
+	fields := make([]string, 0, len(filters))
+	for field := range filters {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	key := text
+	for _, field := range fields {
+		key += " " + field + `:"` + filters[field] + `"`
+	}
+	return key
+}
+
+// readQueryToken reads one whitespace-delimited or double-quoted token
+// starting at *i and advances *i past it.
+func readQueryToken(runes []rune, i *int) string {
+	if *i < len(runes) && runes[*i] == '"' {
+		start := *i + 1
+		end := start
+		for end < len(runes) && runes[end] != '"' {
+			end++
+		}
+		*i = end + 1
+		return string(runes[start:end])
+	}
+
+	start := *i
+	for *i < len(runes) && !unicode.IsSpace(runes[*i]) {
+		*i++
+	}
+	return string(runes[start:*i])
+}
+
+// parseSearchSort turns the sort parameter into an Elasticsearch sort
+// clause. Results are always tie-broken by relevance.
+func parseSearchSort(param string) ([]interface{}, error) {
+	if param == "" || param == "relevance" {
+		return nil, nil
+	}
+
+	order := "asc"
+	if strings.HasPrefix(param, "-") {
+		order = "desc"
+		param = param[1:]
+	}
+	field, ok := searchSortFields[param]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort field: %s", param)
+	}
+
+	return []interface{}{
+		map[string]interface{}{
+			field: map[string]interface{}{
+				"order":         order,
+				"missing":       "_last",
+				"unmapped_type": "date",
+			},
+		},
+		"_score",
+	}, nil
+}
+
+// parseSearchRanges reads the date range parameters into range filter
+// clauses.
+func parseSearchRanges(values url.Values) ([]map[string]interface{}, error) {
+	ranges := map[string]map[string]interface{}{}
+	for param, target := range searchRangeParams {
+		value := values.Get(param)
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return nil, fmt.Errorf("invalid %s date %q, expected YYYY-MM-DD", param, value)
+		}
+		field, op := target[0], target[1]
+		if ranges[field] == nil {
+			ranges[field] = map[string]interface{}{}
+		}
+		ranges[field][op] = value
+	}
+
+	var clauses []map[string]interface{}
+	for field, bounds := range ranges {
+		clauses = append(clauses, map[string]interface{}{
+			"range": map[string]interface{}{
+				field: bounds,
+			},
+		})
+	}
+	return clauses, nil
+}
+
+// searchParamsKey renders the search parameters other than q that
+// affect results in a stable order, for use in cache keys.
+func searchParamsKey(values url.Values) string {
+	var parts []string
+	if sort := values.Get("sort"); sort != "" {
+		parts = append(parts, "sort="+sort)
+	}
+	for _, param := range []string{"created_from", "created_to", "modified_from", "modified_to"} {
+		if value := values.Get(param); value != "" {
+			parts = append(parts, param+"="+value)
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shallowseek/config"
+	"github.com/shallowseek/models"
+	"github.com/shallowseek/webhooks"
+)
+
+var Webhooks = webhooks.NewManager(config.GetWebhooksFile())
+
+func init() {
+	BatchProcessor.AddListener(func(doc models.Document, result string, err error) {
+		data := documentEventData(doc)
+		switch {
+		case err != nil:
+			data["error"] = err.Error()
+			Webhooks.Emit(webhooks.DocumentFailed, data)
+		case result == "updated":
+			Webhooks.Emit(webhooks.DocumentUpdated, data)
+		default:
+			Webhooks.Emit(webhooks.DocumentIndexed, data)
+		}
+	})
+}
+
+func documentEventData(doc models.Document) map[string]interface{} {
+	data := map[string]interface{}{
+		"document_id": doc.ID,
+		"path":        doc.Path,
+		"type":        doc.Type,
+	}
+	if doc.Source != "" {
+		data["source"] = doc.Source
+	}
+	if doc.ParentID != "" {
+		data["parent_id"] = doc.ParentID
+	}
+	return data
+}
+
+func ListWebhooksHandler(c *gin.Context) {
+	subs := Webhooks.List()
+	c.JSON(http.StatusOK, gin.H{"total": len(subs), "webhooks": subs})
+}
+
+// CreateWebhookHandler adds a subscription. The response is the only
+// place its secret is shown.
+func CreateWebhookHandler(c *gin.Context) {
+	sub := webhooks.Subscription{Active: true}
+	if err := c.ShouldBindJSON(&sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook: " + err.Error()})
+		return
+	}
+
+	sub, err := Webhooks.Create(sub)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	log.Printf("[Admin] Created webhook %s for %s", sub.ID, sub.URL)
+	c.JSON(http.StatusCreated, sub)
+}
+
+func GetWebhookHandler(c *gin.Context) {
+	sub, err := Webhooks.Get(c.Param("id"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+func UpdateWebhookHandler(c *gin.Context) {
+	current, err := Webhooks.Get(c.Param("id"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+
+	// Fields left out of the body keep their current values.
+	update := current
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook: " + err.Error()})
+		return
+	}
+
+	sub, err := Webhooks.Update(current.ID, update)
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	log.Printf("[Admin] Updated webhook %s", sub.ID)
+	c.JSON(http.StatusOK, sub)
+}
+
+func DeleteWebhookHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := Webhooks.Delete(id); err != nil {
+		webhookError(c, err)
+		return
+	}
+	log.Printf("[Admin] Deleted webhook %s", id)
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted", "id": id})
+}
+
+func WebhookDeliveriesHandler(c *gin.Context) {
+	deliveries, err := Webhooks.Deliveries(c.Param("id"))
+	if err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"total": len(deliveries), "deliveries": deliveries})
+}
+
+// TestWebhookHandler sends a ping event; its outcome shows up in the
+// delivery log.
+func TestWebhookHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := Webhooks.Ping(id); err != nil {
+		webhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Ping queued", "id": id})
+}
+
+func webhookError(c *gin.Context, err error) {
+	if errors.Is(err, webhooks.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if errors.Is(err, webhooks.ErrInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("[Admin] Webhook error: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
@@ -0,0 +1,195 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shallowseek/config"
+	"github.com/shallowseek/extractor"
+	"github.com/shallowseek/models"
+)
+
+// maxChatFileSize bounds the photos and files of a chat export that are
+// read for indexing.
+const maxChatFileSize = 50 << 20
+
+// ErrNoMessages is returned for a chat export without any message to
+// index, such as one holding only service messages.
+var ErrNoMessages = errors.New("no messages found")
+
+// chatDocuments turns a chat export into one document per message, or
+// per conversation window when CHAT_WINDOW is set, with the chat, its
+// author and its time as fields. The photos and files sent are read
+// from dir and indexed as attachments of their message's document.
+func chatDocuments(exportPath string, content []byte, dir fs.FS) ([]models.Document, error) {
+	chats, err := extractor.ExtractChats(content)
+	if err != nil {
+		return nil, err
+	}
+	window := config.GetChatWindow()
+	windowMessages := config.GetChatWindowMessages()
+
+	var docs []models.Document
+	messages, attachments, missing := 0, 0, 0
+	for _, chat := range chats {
+		for _, group := range chatWindows(chat.Messages, window, windowMessages) {
+			doc := chatDocument(exportPath, chat, group)
+			if doc.Content == "" {
+				continue
+			}
+			docs = append(docs, doc)
+			messages += len(group)
+
+			for _, msg := range group {
+				for _, file := range msg.Files {
+					attDocs, ok := chatAttachment(dir, doc, file)
+					if !ok {
+						missing++
+					}
+					attachments += len(attDocs)
+					docs = append(docs, attDocs...)
+				}
+			}
+		}
+	}
+
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("%w in chat export", ErrNoMessages)
+	}
+
+	log.Printf("[Ingest] Extracted %d messages of %d chats and %d attachments from %s", messages, len(chats), attachments, exportPath)
+	if missing > 0 {
+		log.Printf("[Ingest] %d files of %s were not available to index", missing, exportPath)
+	}
+	return docs, nil
+}
+
+// chatWindows groups messages into windows of at most max messages, each
+// less than window after the one before. A window of 0 puts every
+// message on its own.
+func chatWindows(messages []*extractor.ChatMessage, window time.Duration, max int) [][]*extractor.ChatMessage {
+	var groups [][]*extractor.ChatMessage
+	for _, msg := range messages {
+		if n := len(groups); n > 0 && window > 0 {
+			last := groups[n-1]
+			if len(last) < max && msg.Date.Sub(last[len(last)-1].Date) < window {
+				groups[n-1] = append(last, msg)
+				continue
+			}
+		}
+		groups = append(groups, []*extractor.ChatMessage{msg})
+	}
+	return groups
+}
+
+func chatDocument(exportPath string, chat *extractor.Chat, group []*extractor.ChatMessage) models.Document {
+	first, last := group[0], group[len(group)-1]
+	doc := models.Document{
+		ID:      models.GenerateID(),
+		Type:    ".txt",
+		Chat:    chat.Name,
+		Indexed: time.Now(),
+	}
+	doc.Title = chat.Name
+	if !first.Date.IsZero() {
+		date := first.Date
+		doc.Date = &date
+		doc.Created = &date
+	}
+
+	var authors []string
+	seen := make(map[string]bool)
+	for _, msg := range group {
+		if msg.From != "" && !seen[msg.From] {
+			seen[msg.From] = true
+			authors = append(authors, msg.From)
+		}
+	}
+	doc.From = strings.Join(authors, ", ")
+	doc.Author = doc.From
+
+	if len(group) == 1 {
+		doc.Path = fmt.Sprintf("%s/%d/%d.txt", exportPath, chat.ID, first.ID)
+		doc.Content = chatMessageText(first)
+		return doc
+	}
+
+	// A window reads as a transcript, with a passage per message.
+	doc.Path = fmt.Sprintf("%s/%d/%d-%d.txt", exportPath, chat.ID, first.ID, last.ID)
+	var lines []string
+	for _, msg := range group {
+		text := chatMessageText(msg)
+		if text == "" {
+			continue
+		}
+		location := msg.From
+		if !msg.Date.IsZero() {
+			location = strings.TrimSpace(location + " " + msg.Date.Local().Format("2006-01-02 15:04"))
+		}
+		lines = append(lines, location+": "+text)
+		doc.Passages = append(doc.Passages, models.Passage{Text: text, Location: location})
+	}
+	doc.Content = strings.Join(lines, "\n")
+	return doc
+}
+
+// chatMessageText is the searchable text of a message, naming the files
+// sent with it.
+func chatMessageText(msg *extractor.ChatMessage) string {
+	var parts []string
+	if msg.ForwardedFrom != "" {
+		parts = append(parts, "Forwarded from "+msg.ForwardedFrom)
+	}
+	if msg.Text != "" {
+		parts = append(parts, msg.Text)
+	}
+	for _, file := range msg.Files {
+		parts = append(parts, "["+path.Base(file)+"]")
+	}
+	return strings.Join(parts, "\n")
+}
+
+// chatAttachment indexes a file sent in a chat as an attachment of doc.
+// It reports false if the file couldn't be read.
+func chatAttachment(dir fs.FS, doc models.Document, file string) ([]models.Document, bool) {
+	name := path.Base(file)
+	attPath := doc.Path + "/" + name
+	ext := strings.ToLower(filepath.Ext(name))
+	if !IsSupported(ext) || extractor.IsChatFormat(ext) {
+		return nil, true
+	}
+	if dir == nil {
+		return nil, false
+	}
+
+	f, err := dir.Open(path.Clean(file))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || info.Size() > maxChatFileSize {
+		log.Printf("[Ingest] Skipping attachment %s: too large or unreadable", attPath)
+		return nil, true
+	}
+	content, err := io.ReadAll(f)
+	if err != nil || len(content) == 0 {
+		return nil, false
+	}
+
+	docs, err := documents(attPath, ext, content, doc.ID, 1)
+	if err != nil {
+		log.Printf("[Ingest] Error extracting attachment %s: %v", attPath, err)
+		return nil, true
+	}
+	for i := range docs {
+		docs[i].Chat = doc.Chat
+	}
+	return docs, true
+}
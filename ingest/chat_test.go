@@ -0,0 +1,37 @@
+package ingest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDocumentsChatWithoutMessages(t *testing.T) {
+	exports := map[string]string{
+		"empty": `{"name": "Команда", "type": "private_group", "id": 1, "messages": []}`,
+		"service only": `{"name": "Команда", "type": "private_group", "id": 1, "messages": [
+			{"id": 1, "type": "service", "date": "2024-03-01T10:00:00", "actor": "Анна", "action": "create_group", "text": ""}
+		]}`,
+	}
+	for name, export := range exports {
+		t.Run(name, func(t *testing.T) {
+			docs, err := Documents("result.json", []byte(export))
+			if !errors.Is(err, ErrNoMessages) {
+				t.Errorf("Documents = %d documents, %v; want ErrNoMessages", len(docs), err)
+			}
+		})
+	}
+}
+
+func TestDocumentsChat(t *testing.T) {
+	export := `{"name": "Команда", "type": "private_group", "id": 1, "messages": [
+		{"id": 1, "type": "service", "date": "2024-03-01T10:00:00", "actor": "Анна", "action": "create_group", "text": ""},
+		{"id": 2, "type": "message", "date": "2024-03-01T10:01:00", "from": "Анна", "text": "Отчёт готов"}
+	]}`
+	docs, err := Documents("result.json", []byte(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Chat != "Команда" {
+		t.Errorf("Documents = %+v, want the one text message", docs)
+	}
+}
@@ -0,0 +1,139 @@
+package ingest
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/shallowseek/models"
+)
+
+// Passages are windows of about passageSize characters, each repeating
+// the last passageOverlap characters of the one before it so a phrase
+// cut by a boundary is still whole in one of them.
+const (
+	passageSize    = 1000
+	passageOverlap = 200
+)
+
+// maxPassages caps the passages of one document. Each is a nested
+// document in the index, whose nested_objects.limit is set above this
+// in elasticsearch.Init; longer texts get longer windows instead.
+const maxPassages = 10000
+
+// chunkPassages splits a document into the passages search scores it by.
+// Passages the extractor found (pages, slides, sheet rows) are kept and
+// only split further when long; other documents are cut into windows
+// over the whole content. Start and End are character offsets into
+// Content wherever the passage text can be found there.
+//
+// A document is split into at most maxPassages: the window size is
+// doubled until it fits, and extractor passages that alone are too many
+// give way to windows over the content.
+func chunkPassages(content string, passages []models.Passage) []models.Passage {
+	if len(passages) > maxPassages {
+		log.Printf("[Ingest] %d passages are more than %d, splitting the whole text instead", len(passages), maxPassages)
+		passages = nil
+	}
+	if len(passages) == 0 {
+		if strings.TrimSpace(content) == "" {
+			return nil
+		}
+		passages = []models.Passage{{Text: content}}
+	}
+
+	for size := passageSize; ; size *= 2 {
+		if chunks := chunkWindows(content, passages, size); len(chunks) <= maxPassages {
+			return chunks
+		}
+	}
+}
+
+// chunkWindows splits each passage into windows of about size
+// characters.
+func chunkWindows(content string, passages []models.Passage, size int) []models.Passage {
+	var chunks []models.Passage
+	cursor, cursorRunes := 0, 0
+	for _, passage := range passages {
+		base := -1
+		if i := strings.Index(content[cursor:], passage.Text); i >= 0 && passage.Text != "" {
+			base = cursorRunes + utf8.RuneCountInString(content[cursor:cursor+i])
+			cursorRunes = base
+			cursor += i
+		}
+
+		windows := splitWindows(passage.Text, size)
+		for n, w := range windows {
+			chunk := passage
+			chunk.Text = w.text
+			if base >= 0 {
+				chunk.Start = base + w.start
+				chunk.End = base + w.end
+			}
+			if chunk.Location == "" {
+				chunk.Location = fmt.Sprintf("Passage %d", len(chunks)+1)
+			} else if len(windows) > 1 {
+				chunk.Location = fmt.Sprintf("%s, part %d", passage.Location, n+1)
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+type window struct {
+	text       string
+	start, end int
+}
+
+// splitWindows cuts text into overlapping windows of about size
+// characters that start and end on word boundaries. Offsets are in
+// characters.
+func splitWindows(text string, size int) []window {
+	runes := []rune(text)
+	var windows []window
+	for start := 0; start < len(runes); {
+		for start < len(runes) && unicode.IsSpace(runes[start]) {
+			start++
+		}
+		if start >= len(runes) {
+			break
+		}
+
+		end := start + size
+		if end >= len(runes) {
+			end = len(runes)
+		} else {
+			// Back off to the last space, unless that would make the
+			// window less than half its size (one very long "word").
+			for cut := end; cut > start+size/2; cut-- {
+				if unicode.IsSpace(runes[cut]) {
+					end = cut
+					break
+				}
+			}
+		}
+
+		trimmed := end
+		for trimmed > start && unicode.IsSpace(runes[trimmed-1]) {
+			trimmed--
+		}
+		windows = append(windows, window{text: string(runes[start:trimmed]), start: start, end: trimmed})
+		if end == len(runes) {
+			break
+		}
+
+		// Step back by the overlap and forward to the next word start.
+		next := end - passageOverlap
+		if next <= start {
+			next = end
+		}
+		for next < end && !unicode.IsSpace(runes[next-1]) {
+			next++
+		}
+		start = next
+	}
+	return windows
+}
@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/shallowseek/models"
+)
+
+func TestChunkPassagesWindows(t *testing.T) {
+	content := strings.Repeat("Квартальный отчёт о продажах по регионам. ", 100)
+	chunks := chunkPassages(content, nil)
+	if len(chunks) < 4 {
+		t.Fatalf("got %d passages, want the text split", len(chunks))
+	}
+	runes := []rune(content)
+	for i, c := range chunks {
+		if n := utf8.RuneCountInString(c.Text); n > passageSize {
+			t.Errorf("passage %d has %d characters", i, n)
+		}
+		if string(runes[c.Start:c.End]) != c.Text {
+			t.Errorf("passage %d offsets %d-%d don't match its text", i, c.Start, c.End)
+		}
+		if i > 0 && c.Start >= chunks[i-1].End {
+			t.Errorf("passage %d doesn't overlap the one before", i)
+		}
+	}
+}
+
+// A text too long for maxPassages windows of the usual size gets longer
+// windows rather than more of them.
+func TestChunkPassagesLargeInput(t *testing.T) {
+	var sb strings.Builder
+	for sb.Len() < 12<<20 {
+		fmt.Fprintf(&sb, "Строка %d журнала событий сервера. ", sb.Len())
+	}
+	content := sb.String()
+
+	chunks := chunkPassages(content, nil)
+	if len(chunks) == 0 || len(chunks) > maxPassages {
+		t.Fatalf("got %d passages, want at most %d", len(chunks), maxPassages)
+	}
+	if last := chunks[len(chunks)-1]; last.End != utf8.RuneCountInString(strings.TrimSpace(content)) {
+		t.Errorf("last passage ends at %d, want the end of the text", last.End)
+	}
+}
+
+func TestChunkPassagesTooManyFromExtractor(t *testing.T) {
+	passages := make([]models.Passage, maxPassages+1)
+	texts := make([]string, len(passages))
+	for i := range passages {
+		texts[i] = fmt.Sprintf("row %d", i+1)
+		passages[i] = models.Passage{Text: texts[i], Location: fmt.Sprintf("Row %d", i+1), Row: i + 1}
+	}
+
+	chunks := chunkPassages(strings.Join(texts, "\n"), passages)
+	if len(chunks) == 0 || len(chunks) > maxPassages {
+		t.Fatalf("got %d passages, want at most %d", len(chunks), maxPassages)
+	}
+	if chunks[0].Row != 0 || chunks[0].Location != "Passage 1" {
+		t.Errorf("first passage = %+v, want a window over the text", chunks[0])
+	}
+}
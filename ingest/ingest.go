@@ -0,0 +1,219 @@
+package ingest
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shallowseek/extractor"
+	"github.com/shallowseek/models"
+)
+
+// maxAttachmentDepth bounds how deep attachments of attachments are
+// followed, so a maliciously nested message can't recurse forever.
+const maxAttachmentDepth = 5
+
+var supportedTypes = map[string]bool{
+	".txt":      true,
+	".pdf":      true,
+	".doc":      true,
+	".docx":     true,
+	".png":      true,
+	".jpg":      true,
+	".jpeg":     true,
+	".tif":      true,
+	".tiff":     true,
+	".odt":      true,
+	".rtf":      true,
+	".html":     true,
+	".htm":      true,
+	".md":       true,
+	".markdown": true,
+	".epub":     true,
+	".xlsx":     true,
+	".ods":      true,
+	".csv":      true,
+	".pptx":     true,
+	".eml":      true,
+	".mbox":     true,
+	".msg":      true,
+	".json":     true,
+}
+
+// IsSupported reports whether files with this extension can be indexed.
+func IsSupported(ext string) bool {
+	return supportedTypes[strings.ToLower(ext)]
+}
+
+// Documents runs a file through the extractor and returns the documents
+// to index for it. Most files give a single document; mail files give
+// one per message plus one per attachment, linked to the message that
+// carried it through ParentID, and chat exports one per message or
+// conversation window. Every document's text is split into the
+// passages it is searched by.
+func Documents(path string, content []byte) ([]models.Document, error) {
+	return DocumentsOfType(path, filepath.Ext(path), content)
+}
+
+// DocumentsOfType is Documents for content whose type isn't given by the
+// extension of its path, such as a web page, given as ext instead.
+func DocumentsOfType(path, ext string, content []byte) ([]models.Document, error) {
+	return documentsIn(path, strings.ToLower(ext), content, nil)
+}
+
+// DocumentsInDir is Documents for a file of a directory, dir, which
+// holds the files it refers to, such as the photos and files of a chat
+// export. Without dir those files are left out.
+func DocumentsInDir(path string, content []byte, dir fs.FS) ([]models.Document, error) {
+	return documentsIn(path, strings.ToLower(filepath.Ext(path)), content, dir)
+}
+
+func documentsIn(path, ext string, content []byte, dir fs.FS) ([]models.Document, error) {
+	var docs []models.Document
+	var err error
+	if extractor.IsChatFormat(ext) {
+		docs, err = chatDocuments(path, content, dir)
+	} else {
+		docs, err = documents(path, ext, content, "", 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range docs {
+		docs[i].Passages = chunkPassages(docs[i].Content, docs[i].Passages)
+	}
+	return docs, nil
+}
+
+// AssignIDs replaces the random IDs of the documents of one source item
+// with IDs derived from key and their order, keeping attachments linked
+// to their messages.
+func AssignIDs(docs []models.Document, key string) {
+	ids := make(map[string]string, len(docs))
+	for i := range docs {
+		id := models.StableID(fmt.Sprintf("%s#%d", key, i))
+		ids[docs[i].ID] = id
+		docs[i].ID = id
+	}
+	for i := range docs {
+		if parent, ok := ids[docs[i].ParentID]; ok {
+			docs[i].ParentID = parent
+		}
+	}
+}
+
+func documents(path, ext string, content []byte, parentID string, depth int) ([]models.Document, error) {
+	if extractor.IsMailFormat(ext) {
+		return messageDocuments(path, ext, content, parentID, depth)
+	}
+
+	extracted, err := extractor.Extract(ext, content)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := models.Document{
+		ID:       models.GenerateID(),
+		Path:     path,
+		Type:     ext,
+		Content:  extracted.Text,
+		Encoding: extracted.Encoding,
+		OCR:      extracted.OCR,
+		Passages: extracted.Passages,
+		ParentID: parentID,
+		Metadata: extracted.Metadata,
+		Indexed:  time.Now(),
+	}
+
+	if extracted.OCR {
+		doc.OCRConfidence = extracted.OCRConfidence
+		log.Printf("[Ingest] Text of %s recognised by OCR with confidence %.1f", path, doc.OCRConfidence)
+	}
+
+	if ext == ".pdf" || extracted.Text != string(content) {
+		doc.OriginalContent = base64.StdEncoding.EncodeToString(content)
+	}
+
+	return []models.Document{doc}, nil
+}
+
+func messageDocuments(path, ext string, content []byte, parentID string, depth int) ([]models.Document, error) {
+	messages, err := extractor.ExtractMessages(ext, content)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []models.Document
+	for i, msg := range messages {
+		doc := models.Document{
+			ID:        models.GenerateID(),
+			Path:      path,
+			Type:      ext,
+			Content:   messageContent(msg),
+			From:      msg.From,
+			To:        msg.To,
+			Subject:   msg.Subject,
+			MessageID: msg.MessageID,
+			ParentID:  parentID,
+			Indexed:   time.Now(),
+		}
+		doc.Title = msg.Subject
+		doc.Author = msg.From
+		if !msg.Date.IsZero() {
+			date := msg.Date
+			doc.Date = &date
+			doc.Created = &date
+		}
+
+		switch {
+		case len(messages) > 1:
+			// Messages of a mailbox are stored and downloaded one by one.
+			doc.Path = fmt.Sprintf("%s/%d.eml", path, i+1)
+			doc.Type = ".eml"
+			doc.OriginalContent = base64.StdEncoding.EncodeToString(msg.Raw)
+		default:
+			doc.OriginalContent = base64.StdEncoding.EncodeToString(content)
+		}
+		docs = append(docs, doc)
+
+		for _, att := range msg.Attachments {
+			attPath := doc.Path + "/" + att.Filename
+			attExt := strings.ToLower(filepath.Ext(att.Filename))
+			if !IsSupported(attExt) {
+				log.Printf("[Ingest] Skipping attachment %s: unsupported type", attPath)
+				continue
+			}
+			if depth >= maxAttachmentDepth {
+				log.Printf("[Ingest] Skipping attachment %s: nested too deep", attPath)
+				continue
+			}
+			if len(att.Content) == 0 {
+				continue
+			}
+
+			attDocs, err := documents(attPath, attExt, att.Content, doc.ID, depth+1)
+			if err != nil {
+				log.Printf("[Ingest] Error extracting attachment %s: %v", attPath, err)
+				continue
+			}
+			docs = append(docs, attDocs...)
+		}
+	}
+
+	log.Printf("[Ingest] Extracted %d messages and %d attachments from %s", len(messages), len(docs)-len(messages), path)
+	return docs, nil
+}
+
+// messageContent is the searchable text of a message: its subject
+// followed by its body.
+func messageContent(msg *extractor.Message) string {
+	content := strings.TrimSpace(msg.Subject + "\n\n" + msg.Body)
+	if content == "" {
+		return "(empty message)"
+	}
+	return content
+}
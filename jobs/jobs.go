@@ -0,0 +1,249 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shallowseek/models"
+)
+
+type Status string
+
+// An upload moves through these states in order, ending in Indexed or
+// Failed.
+const (
+	Queued     Status = "queued"
+	Extracting Status = "extracting"
+	Indexing   Status = "indexing"
+	Indexed    Status = "indexed"
+	Failed     Status = "failed"
+)
+
+// retention is how long finished jobs stay available.
+const retention = 24 * time.Hour
+
+// Job is the indexing progress of one upload. A file gives one document
+// or, for mail, one per message and attachment; the job is indexed once
+// all of them are.
+type Job struct {
+	ID          string        `json:"id"`
+	Filename    string        `json:"filename"`
+	Status      Status        `json:"status"`
+	DocumentIDs []string      `json:"document_ids,omitempty"`
+	Indexed     int           `json:"indexed"`
+	Failed      int           `json:"failed"`
+	Error       string        `json:"error,omitempty"`
+	Errors      []DocumentErr `json:"errors,omitempty"`
+	Created     time.Time     `json:"created"`
+	Updated     time.Time     `json:"updated"`
+}
+
+// DocumentErr is why one document of a job could not be indexed.
+type DocumentErr struct {
+	DocumentID string `json:"document_id"`
+	Error      string `json:"error"`
+}
+
+// Finished reports whether the job has reached a final state.
+func (j Job) Finished() bool {
+	return j.Status == Indexed || j.Status == Failed
+}
+
+type jobState struct {
+	job  Job
+	docs map[string]string // document ID -> "", or the error that failed it
+	done map[string]bool
+}
+
+type subscriber struct {
+	jobID string
+	ch    chan Job
+}
+
+// Tracker keeps job records in memory and tells subscribers about every
+// change. Records don't survive a restart; the documents themselves do,
+// through the batch queue's write-ahead log.
+type Tracker struct {
+	mu          sync.Mutex
+	jobs        map[string]*jobState
+	byDocument  map[string]string
+	subscribers map[*subscriber]bool
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		jobs:        make(map[string]*jobState),
+		byDocument:  make(map[string]string),
+		subscribers: make(map[*subscriber]bool),
+	}
+}
+
+// Create starts a queued job for an uploaded file.
+func (t *Tracker) Create(filename string) Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune()
+	now := time.Now()
+	state := &jobState{
+		job: Job{
+			ID:       models.GenerateID(),
+			Filename: filename,
+			Status:   Queued,
+			Created:  now,
+			Updated:  now,
+		},
+		docs: make(map[string]string),
+		done: make(map[string]bool),
+	}
+	t.jobs[state.job.ID] = state
+	t.publish(state)
+	return state.job
+}
+
+func (t *Tracker) Get(id string) (Job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return state.snapshot(), true
+}
+
+// Extracting marks a job as having its text extracted.
+func (t *Tracker) Extracting(id string) {
+	t.update(id, func(state *jobState) {
+		state.job.Status = Extracting
+	})
+}
+
+// Track moves a job to indexing and records the documents it waits for.
+func (t *Tracker) Track(id string, documentIDs []string) {
+	t.update(id, func(state *jobState) {
+		state.job.Status = Indexing
+		for _, docID := range documentIDs {
+			state.job.DocumentIDs = append(state.job.DocumentIDs, docID)
+			state.docs[docID] = ""
+			t.byDocument[docID] = id
+		}
+	})
+}
+
+// Fail ends a job with an error that concerns it as a whole, such as a
+// file that could not be read.
+func (t *Tracker) Fail(id string, err error) {
+	t.update(id, func(state *jobState) {
+		state.job.Status = Failed
+		state.job.Error = err.Error()
+	})
+}
+
+// DocumentDone records the outcome of indexing one document: err is nil
+// once it is searchable. A document that failed can still succeed later,
+// after it is requeued from the dead letter store.
+func (t *Tracker) DocumentDone(docID string, err error) {
+	t.mu.Lock()
+	id, ok := t.byDocument[docID]
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	t.update(id, func(state *jobState) {
+		state.done[docID] = true
+		state.docs[docID] = ""
+		if err != nil {
+			state.docs[docID] = err.Error()
+		}
+
+		if state.job.Error != "" || len(state.done) < len(state.docs) {
+			return
+		}
+		state.job.Status = Indexed
+		for _, docErr := range state.docs {
+			if docErr != "" {
+				state.job.Status = Failed
+			}
+		}
+	})
+}
+
+// Subscribe returns a channel that receives the job's state after every
+// change, or every job's if id is empty. Only the latest state is kept
+// for a slow reader. The returned function ends the subscription.
+func (t *Tracker) Subscribe(id string) (<-chan Job, func()) {
+	sub := &subscriber{jobID: id, ch: make(chan Job, 1)}
+
+	t.mu.Lock()
+	t.subscribers[sub] = true
+	t.mu.Unlock()
+
+	return sub.ch, func() {
+		t.mu.Lock()
+		delete(t.subscribers, sub)
+		t.mu.Unlock()
+	}
+}
+
+func (t *Tracker) update(id string, fn func(state *jobState)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.jobs[id]
+	if !ok {
+		return
+	}
+	fn(state)
+	state.job.Updated = time.Now()
+	t.publish(state)
+}
+
+// publish sends a job's state to its subscribers, replacing a state
+// they haven't read yet. Called with t.mu held.
+func (t *Tracker) publish(state *jobState) {
+	job := state.snapshot()
+	for sub := range t.subscribers {
+		if sub.jobID != "" && sub.jobID != job.ID {
+			continue
+		}
+		select {
+		case <-sub.ch:
+		default:
+		}
+		sub.ch <- job
+	}
+}
+
+// prune drops jobs that finished more than retention ago. Called with
+// t.mu held.
+func (t *Tracker) prune() {
+	cutoff := time.Now().Add(-retention)
+	for id, state := range t.jobs {
+		if state.job.Finished() && state.job.Updated.Before(cutoff) {
+			for _, docID := range state.job.DocumentIDs {
+				delete(t.byDocument, docID)
+			}
+			delete(t.jobs, id)
+		}
+	}
+}
+
+func (s *jobState) snapshot() Job {
+	job := s.job
+	job.DocumentIDs = append([]string(nil), s.job.DocumentIDs...)
+	job.Indexed, job.Failed, job.Errors = 0, 0, nil
+	for _, docID := range s.job.DocumentIDs {
+		if !s.done[docID] {
+			continue
+		}
+		if docErr := s.docs[docID]; docErr != "" {
+			job.Failed++
+			job.Errors = append(job.Errors, DocumentErr{DocumentID: docID, Error: docErr})
+		} else {
+			job.Indexed++
+		}
+	}
+	return job
+}
@@ -8,8 +8,11 @@ import (
 	"syscall"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shallowseek/apikeys"
 	"github.com/shallowseek/cache"
 	"github.com/shallowseek/config"
+	"github.com/shallowseek/connectors"
 	"github.com/shallowseek/elasticsearch"
 	"github.com/shallowseek/handlers"
 )
@@ -19,8 +22,92 @@ func main() {
 		log.Fatalf("Failed to initialize Elasticsearch: %v", err)
 	}
 
+	if err := handlers.BatchProcessor.RestoreIndexSettings(); err != nil {
+		log.Printf("Warning: Failed to restore index settings after interrupted import: %v", err)
+	}
+
 	if err := cache.Init(); err != nil {
-		log.Printf("Warning: Failed to initialize cache: %v", err)
+		log.Printf("Warning: Redis unavailable, caching in process until it is back: %v", err)
+	}
+
+	for _, scope := range config.GetAnonymousScopes() {
+		if !apikeys.ValidScope(scope) {
+			log.Fatalf("Unknown scope in API_ANONYMOUS_SCOPES: %s", scope)
+		}
+	}
+	if len(handlers.APIKeys.List()) == 0 {
+		log.Printf("Warning: No API keys; set API_BOOTSTRAP_KEY to create some")
+	}
+
+	folder, err := connectors.NewFolder(config.GetWatchDirs(), handlers.ConnectorIndex, config.GetConnectorStateDir(), config.GetWatchScanInterval())
+	if err != nil {
+		log.Fatalf("Failed to initialize folder connector: %v", err)
+	}
+	handlers.Connectors = append(handlers.Connectors, folder)
+
+	if locations := config.GetS3Locations(); len(locations) > 0 {
+		s3, err := connectors.NewS3(connectors.S3Config{
+			Endpoint:  config.GetS3Endpoint(),
+			Region:    config.GetS3Region(),
+			AccessKey: config.GetS3AccessKey(),
+			SecretKey: config.GetS3SecretKey(),
+			PathStyle: config.GetS3PathStyle(),
+			Locations: locations,
+			Interval:  config.GetS3SyncInterval(),
+		}, handlers.ConnectorIndex, config.GetConnectorStateDir())
+		if err != nil {
+			log.Fatalf("Failed to initialize S3 connector: %v", err)
+		}
+		handlers.Connectors = append(handlers.Connectors, s3)
+	}
+
+	if seeds := config.GetCrawlSeeds(); len(seeds) > 0 {
+		crawler, err := connectors.NewCrawler(connectors.CrawlerConfig{
+			Seeds:          seeds,
+			AllowedDomains: config.GetCrawlAllowedDomains(),
+			AllowedPaths:   config.GetCrawlAllowedPaths(),
+			MaxDepth:       config.GetCrawlMaxDepth(),
+			MaxPages:       config.GetCrawlMaxPages(),
+			Delay:          config.GetCrawlDelay(),
+			UserAgent:      config.GetCrawlUserAgent(),
+			Interval:       config.GetCrawlInterval(),
+		}, handlers.ConnectorIndex, config.GetConnectorStateDir())
+		if err != nil {
+			log.Fatalf("Failed to initialize crawler: %v", err)
+		}
+		handlers.Connectors = append(handlers.Connectors, crawler)
+	}
+
+	if addr := config.GetIMAPAddr(); addr != "" {
+		imap, err := connectors.NewIMAP(connectors.IMAPConfig{
+			Addr:     addr,
+			TLS:      config.GetIMAPTLS(),
+			Username: config.GetIMAPUsername(),
+			Password: config.GetIMAPPassword(),
+			Folders:  config.GetIMAPFolders(),
+			Interval: config.GetIMAPSyncInterval(),
+		}, handlers.ConnectorIndex, config.GetConnectorStateDir())
+		if err != nil {
+			log.Fatalf("Failed to initialize IMAP connector: %v", err)
+		}
+		handlers.Connectors = append(handlers.Connectors, imap)
+	}
+
+	if webdavURL := config.GetWebDAVURL(); webdavURL != "" {
+		webdav, err := connectors.NewWebDAV(connectors.WebDAVConfig{
+			URL:      webdavURL,
+			Username: config.GetWebDAVUsername(),
+			Password: config.GetWebDAVPassword(),
+			Interval: config.GetWebDAVSyncInterval(),
+		}, handlers.ConnectorIndex, config.GetConnectorStateDir())
+		if err != nil {
+			log.Fatalf("Failed to initialize WebDAV connector: %v", err)
+		}
+		handlers.Connectors = append(handlers.Connectors, webdav)
+	}
+
+	for _, conn := range handlers.Connectors {
+		conn.Start()
 	}
 
 	r := gin.Default()
@@ -28,15 +115,49 @@ func main() {
 	r.Static("/static", "./static")
 	r.LoadHTMLGlob("templates/*")
 
-	api := r.Group("/api")
+	api := r.Group("/api", handlers.Authenticate())
 	{
-		api.GET("/search", gin.WrapF(handlers.SearchHandler))
-		api.POST("/upload", handlers.UploadFileHandler)
-		api.GET("/documents/:id/download", handlers.DownloadDocumentHandler)
-		api.GET("/documents/:id/view", handlers.ViewDocumentHandler)
-		api.GET("/status", gin.WrapF(handlers.StatusHandler))
+		search := handlers.RequireScope(apikeys.ScopeSearch)
+		upload := handlers.RequireScope(apikeys.ScopeUpload)
+		api.GET("/search", search, gin.WrapF(handlers.SearchHandler))
+		api.POST("/upload", upload, handlers.UploadFileHandler)
+		api.GET("/documents/:id/download", search, handlers.DownloadDocumentHandler)
+		api.GET("/documents/:id/view", search, handlers.ViewDocumentHandler)
+		api.DELETE("/documents/:id", handlers.RequireScope(apikeys.ScopeDelete), handlers.DeleteDocumentHandler)
+		api.GET("/status", search, gin.WrapF(handlers.StatusHandler))
+		api.GET("/jobs/:id", upload, handlers.GetJobHandler)
+		api.GET("/jobs/:id/events", upload, handlers.JobEventsHandler)
+
+		admin := api.Group("/admin", handlers.RequireScope(apikeys.ScopeAdmin))
+		admin.GET("/deadletters", handlers.ListDeadLettersHandler)
+		admin.GET("/deadletters/:id", handlers.GetDeadLetterHandler)
+		admin.PUT("/deadletters/:id", handlers.FixDeadLetterHandler)
+		admin.POST("/deadletters/:id/requeue", handlers.RequeueDeadLetterHandler)
+		admin.DELETE("/deadletters/:id", handlers.DeleteDeadLetterHandler)
+		admin.GET("/import", handlers.ImportStatusHandler)
+		admin.POST("/import/start", handlers.StartImportHandler)
+		admin.POST("/import/finish", handlers.FinishImportHandler)
+		admin.GET("/connectors", handlers.ListConnectorsHandler)
+		admin.POST("/connectors/:name/sync", handlers.SyncConnectorHandler)
+		admin.GET("/webhooks", handlers.ListWebhooksHandler)
+		admin.POST("/webhooks", handlers.CreateWebhookHandler)
+		admin.GET("/webhooks/:id", handlers.GetWebhookHandler)
+		admin.PUT("/webhooks/:id", handlers.UpdateWebhookHandler)
+		admin.DELETE("/webhooks/:id", handlers.DeleteWebhookHandler)
+		admin.GET("/webhooks/:id/deliveries", handlers.WebhookDeliveriesHandler)
+		admin.POST("/webhooks/:id/test", handlers.TestWebhookHandler)
+		admin.GET("/cache", handlers.CacheStatsHandler)
+		admin.POST("/cache/flush", handlers.FlushCacheHandler)
+		admin.POST("/cache/prewarm", handlers.PrewarmCacheHandler)
+		admin.GET("/keys", handlers.ListAPIKeysHandler)
+		admin.POST("/keys", handlers.CreateAPIKeyHandler)
+		admin.GET("/keys/:id", handlers.GetAPIKeyHandler)
+		admin.DELETE("/keys/:id", handlers.RevokeAPIKeyHandler)
 	}
 
+	// Metrics name every API key and its traffic, so they are for admins.
+	r.GET("/metrics", handlers.Authenticate(), handlers.RequireScope(apikeys.ScopeAdmin), gin.WrapH(promhttp.Handler()))
+
 	r.GET("/", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.html", nil)
 	})
@@ -64,6 +185,9 @@ func main() {
 	case sig := <-shutdown:
 		log.Printf("Shutdown signal received: %v", sig)
 
+		for _, conn := range handlers.Connectors {
+			conn.Stop()
+		}
 		handlers.BatchProcessor.Stop()
 
 		if err := srv.Close(); err != nil {
@@ -21,10 +21,86 @@ var (
 		Help:    "Duration of file upload processing in seconds",
 		Buckets: prometheus.DefBuckets,
 	})
+
+	BulkQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "shallowseek_bulk_queue_documents",
+		Help: "Number of documents waiting to be indexed or being indexed",
+	})
+
+	BulkQueueBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "shallowseek_bulk_queue_bytes",
+		Help: "Size of the documents waiting to be indexed or being indexed",
+	})
+
+	BulkBatchDocuments = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shallowseek_bulk_batch_documents",
+		Help:    "Number of documents per bulk request",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+	})
+
+	BulkBatchBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shallowseek_bulk_batch_bytes",
+		Help:    "Size of bulk requests in bytes",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+
+	BulkDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shallowseek_bulk_duration_seconds",
+		Help:    "Duration of bulk requests in seconds",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	BulkRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "shallowseek_bulk_rejected_total",
+		Help: "Number of uploads turned away because the indexing queue was full",
+	})
+
+	CacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shallowseek_cache_hits_total",
+		Help: "Number of search cache lookups answered, by tier",
+	}, []string{"tier"})
+
+	CacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shallowseek_cache_misses_total",
+		Help: "Number of search cache lookups not answered, by tier",
+	}, []string{"tier"})
+
+	CacheErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shallowseek_cache_errors_total",
+		Help: "Number of search cache calls that failed or were skipped while the tier was unavailable, by tier",
+	}, []string{"tier"})
+
+	CacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shallowseek_cache_evictions_total",
+		Help: "Number of entries evicted for lack of room, by tier; for Redis, every key the server evicted",
+	}, []string{"tier"})
+
+	CacheDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shallowseek_cache_duration_seconds",
+		Help:    "Duration of search cache calls in seconds, by tier and operation",
+		Buckets: prometheus.ExponentialBuckets(0.00005, 4, 9),
+	}, []string{"tier", "operation"})
+
+	APIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shallowseek_api_requests_total",
+		Help: "Number of API requests, by API key ID and response status",
+	}, []string{"key", "status"})
 )
 
 func init() {
 	prometheus.MustRegister(DocumentCount)
 	prometheus.MustRegister(SearchDuration)
 	prometheus.MustRegister(UploadDuration)
+	prometheus.MustRegister(BulkQueueDepth)
+	prometheus.MustRegister(BulkQueueBytes)
+	prometheus.MustRegister(BulkBatchDocuments)
+	prometheus.MustRegister(BulkBatchBytes)
+	prometheus.MustRegister(BulkDuration)
+	prometheus.MustRegister(BulkRejected)
+	prometheus.MustRegister(CacheHits)
+	prometheus.MustRegister(CacheMisses)
+	prometheus.MustRegister(CacheErrors)
+	prometheus.MustRegister(CacheEvictions)
+	prometheus.MustRegister(CacheDuration)
+	prometheus.MustRegister(APIRequests)
 } 
\ No newline at end of file
@@ -7,12 +7,52 @@ import (
 )
 
 type Document struct {
-	ID              string    `json:"id"`
-	Path            string    `json:"path"`
-	Type            string    `json:"type"`
-	Content         string    `json:"content"`
-	OriginalContent string    `json:"original_content,omitempty"`
-	Indexed         time.Time `json:"indexed"`
+	ID              string     `json:"id"`
+	Path            string     `json:"path"`
+	Type            string     `json:"type"`
+	Content         string     `json:"content"`
+	OriginalContent string     `json:"original_content,omitempty"`
+	Encoding        string     `json:"encoding,omitempty"`
+	OCR             bool       `json:"ocr,omitempty"`
+	OCRConfidence   float64    `json:"ocr_confidence,omitempty"`
+	Passages        []Passage  `json:"passages,omitempty"`
+	From            string     `json:"from,omitempty"`
+	To              []string   `json:"to,omitempty"`
+	Subject         string     `json:"subject,omitempty"`
+	Date            *time.Time `json:"date,omitempty"`
+	MessageID       string     `json:"message_id,omitempty"`
+	Chat            string     `json:"chat,omitempty"`
+	ParentID        string     `json:"parent_id,omitempty"`
+	Source          string     `json:"source,omitempty"`
+	Metadata
+	Indexed time.Time `json:"indexed"`
+}
+
+// Metadata is the descriptive information stored inside a file, such
+// as a PDF information dictionary or an office document's properties.
+type Metadata struct {
+	Title     string     `json:"title,omitempty"`
+	Author    string     `json:"author,omitempty"`
+	Created   *time.Time `json:"created,omitempty"`
+	Modified  *time.Time `json:"modified,omitempty"`
+	PageCount int        `json:"page_count,omitempty"`
+	Language  string     `json:"language,omitempty"`
+	Producer  string     `json:"producer,omitempty"`
+}
+
+// Passage is a piece of a document that can be pointed at on its own,
+// such as a run of spreadsheet cells, a slide, a PDF page or a window of
+// a long text. Start and End are character offsets into the content.
+type Passage struct {
+	Text     string `json:"text"`
+	Location string `json:"location,omitempty"`
+	Sheet    string `json:"sheet,omitempty"`
+	Row      int    `json:"row,omitempty"`
+	Column   string `json:"column,omitempty"`
+	Slide    int    `json:"slide,omitempty"`
+	Page     int    `json:"page,omitempty"`
+	Start    int    `json:"start,omitempty"`
+	End      int    `json:"end,omitempty"`
 }
 
 type SearchResult struct {
@@ -28,6 +68,12 @@ func GenerateID() string {
 	return uuid.New().String()
 }
 
+// StableID derives an ID from a key, so a document found again by a
+// connector replaces the one indexed before instead of adding a copy.
+func StableID(key string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(key)).String()
+}
+
 type SearchRequest struct {
     Query string `json:"query"`
 }
@@ -47,12 +93,32 @@ type SimplifiedSearchResult struct {
 }
 
 type SimplifiedDocument struct {
-    ID          string    `json:"id"`
-    Path        string    `json:"path"`
-    Type        string    `json:"type"`
-    Indexed     time.Time `json:"indexed"`
-    Score       float64   `json:"relevance_score"`
-    Snippets    []string  `json:"snippets"`
-    DownloadURL string    `json:"download_url"`
-    ViewURL     string    `json:"view_url,omitempty"`
-}
\ No newline at end of file
+    ID          string       `json:"id"`
+    Path        string       `json:"path"`
+    Type        string       `json:"type"`
+    Indexed     time.Time    `json:"indexed"`
+    Score       float64      `json:"relevance_score"`
+    Snippets    []string     `json:"snippets"`
+    Passages    []PassageHit `json:"passages,omitempty"`
+    From        string       `json:"from,omitempty"`
+    Subject     string       `json:"subject,omitempty"`
+    Date        *time.Time   `json:"date,omitempty"`
+    Chat        string       `json:"chat,omitempty"`
+    ParentID    string       `json:"parent_id,omitempty"`
+    Metadata
+    DownloadURL string       `json:"download_url"`
+    ViewURL     string       `json:"view_url,omitempty"`
+}
+
+type PassageHit struct {
+    Snippet  string `json:"snippet"`
+    Location string `json:"location"`
+    Sheet    string `json:"sheet,omitempty"`
+    Row      int    `json:"row,omitempty"`
+    Column   string `json:"column,omitempty"`
+    Slide    int    `json:"slide,omitempty"`
+    Page     int    `json:"page,omitempty"`
+    Start    int    `json:"start,omitempty"`
+    End      int    `json:"end,omitempty"`
+    ViewURL  string `json:"view_url,omitempty"`
+}
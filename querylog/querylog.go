@@ -0,0 +1,160 @@
+// Package querylog records the searches served, one JSON line each, so
+// the most frequent ones can be found again, such as to pre-warm the
+// cache. The log is rotated once it reaches its size limit, keeping the
+// previous file.
+package querylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is one search. Query is the normalized query and Params the
+// other parameters that affect results, URL-encoded. Key is the ID of
+// the API key it was made with.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Key        string    `json:"key,omitempty"`
+	Query      string    `json:"query"`
+	Params     string    `json:"params,omitempty"`
+	Results    int       `json:"results"`
+	DurationMs int64     `json:"duration_ms"`
+	Cache      string    `json:"cache"`
+}
+
+// QueryCount is a search with the number of times it was made.
+type QueryCount struct {
+	Query  string `json:"query"`
+	Params string `json:"params,omitempty"`
+	Count  int    `json:"count"`
+}
+
+type Log struct {
+	path     string
+	maxBytes int64
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func New(path string, maxBytes int64) *Log {
+	return &Log{path: path, maxBytes: maxBytes}
+}
+
+// Record appends an entry. Failures are only logged: a search is never
+// failed for its log.
+func (l *Log) Record(entry Entry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.open(); err != nil {
+		log.Printf("[QueryLog] Error opening %s: %v", l.path, err)
+		return
+	}
+	if l.size+int64(len(data)) > l.maxBytes {
+		l.rotate()
+		if err := l.open(); err != nil {
+			log.Printf("[QueryLog] Error opening %s: %v", l.path, err)
+			return
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("[QueryLog] Error writing %s: %v", l.path, err)
+	}
+}
+
+// open is called with l.mu held.
+func (l *Log) open() error {
+	if l.file != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// rotate is called with l.mu held.
+func (l *Log) rotate() {
+	l.file.Close()
+	l.file = nil
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		log.Printf("[QueryLog] Error rotating %s: %v", l.path, err)
+	}
+}
+
+// Top returns the n searches made most often since a time, the previous
+// file included, most frequent first.
+func (l *Log) Top(n int, since time.Time) ([]QueryCount, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := make(map[[2]string]int)
+	for _, path := range []string{l.path + ".1", l.path} {
+		if err := count(path, since, counts); err != nil {
+			return nil, err
+		}
+	}
+
+	top := make([]QueryCount, 0, len(counts))
+	for key, c := range counts {
+		top = append(top, QueryCount{Query: key[0], Params: key[1], Count: c})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Query+top[i].Params < top[j].Query+top[j].Params
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top, nil
+}
+
+func count(path string, since time.Time, counts map[[2]string]int) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.Time.Before(since) {
+			continue
+		}
+		counts[[2]string{entry.Query, entry.Params}]++
+	}
+	return scanner.Err()
+}
@@ -8,6 +8,34 @@ document.addEventListener('DOMContentLoaded', () => {
     const progressDiv = document.getElementById('uploadProgress');
     const progressFill = progressDiv.querySelector('.progress-fill');
     const progressText = progressDiv.querySelector('.progress-text');
+    const apiKeyForm = document.getElementById('apiKeyForm');
+    const apiKeyInput = document.getElementById('apiKeyInput');
+
+    const escapeHtml = (value) => String(value ?? '')
+        .replace(/&/g, '&amp;')
+        .replace(/</g, '&lt;')
+        .replace(/>/g, '&gt;')
+        .replace(/"/g, '&quot;')
+        .replace(/'/g, '&#39;');
+
+    // Snippets are document text with <mark> around the matches; only
+    // those tags are kept.
+    const escapeSnippet = (snippet) => escapeHtml(snippet)
+        .replace(/&lt;(\/?)mark&gt;/g, '<$1mark>');
+
+    // Our own documents need the API key and are opened by openDocument;
+    // crawled pages and WebDAV files are plain links to where they are,
+    // which must never be sent the key.
+    const isAPIPath = (url) => url.startsWith('/api/');
+    const documentLink = (url, label, download = false) => {
+        if (isAPIPath(url)) {
+            return `<a href="#" data-url="${escapeHtml(url)}"${download ? ' data-download="true"' : ''}>${escapeHtml(label)}</a>`;
+        }
+        if (/^https?:\/\//i.test(url)) {
+            return `<a href="${escapeHtml(url)}" target="_blank" rel="noopener noreferrer">${escapeHtml(label)}</a>`;
+        }
+        return escapeHtml(label);
+    };
 
     const showLoading = () => loadingDiv.style.display = 'block';
     const hideLoading = () => loadingDiv.style.display = 'none';
@@ -20,9 +48,86 @@ document.addEventListener('DOMContentLoaded', () => {
         setTimeout(() => message.remove(), 3000);
     };
 
+    // The API key is kept in localStorage and sent with every request.
+    // A 401 forgets it and stops there, so nothing is shown without one.
+    const apiKeyStorage = 'shallowseekApiKey';
+    apiKeyInput.value = localStorage.getItem(apiKeyStorage) || '';
+
+    apiKeyForm.addEventListener('submit', (e) => {
+        e.preventDefault();
+        const key = apiKeyInput.value.trim();
+        if (key) {
+            localStorage.setItem(apiKeyStorage, key);
+        } else {
+            localStorage.removeItem(apiKeyStorage);
+        }
+        showMessage(key ? 'API key saved' : 'API key removed');
+        updateDocCount();
+    });
+
+    const apiFetch = async (url, options = {}) => {
+        const headers = new Headers(options.headers || {});
+        const key = localStorage.getItem(apiKeyStorage);
+        if (key) headers.set('X-API-Key', key);
+        const response = await fetch(url, { ...options, headers });
+        if (response.status === 401) {
+            localStorage.removeItem(apiKeyStorage);
+            apiKeyInput.value = '';
+            apiKeyInput.focus();
+            throw new Error('API key missing or rejected, enter a valid key');
+        }
+        return response;
+    };
+
+    // Downloads and views need the key too, so they are fetched rather
+    // than linked and opened from a blob URL.
+    const openDocument = async (url, download) => {
+        if (!isAPIPath(url)) return;
+        const [path, fragment] = url.split('#');
+        // Opened before fetching, or the popup blocker stops it.
+        const win = download ? null : window.open('', '_blank');
+        try {
+            const response = await apiFetch(path);
+            if (!response.ok) throw new Error(await response.text());
+            const blob = await response.blob();
+            const blobURL = URL.createObjectURL(blob);
+            setTimeout(() => URL.revokeObjectURL(blobURL), 60000);
+
+            if (download) {
+                const disposition = response.headers.get('Content-Disposition') || '';
+                const name = /filename="?([^";]+)"?/.exec(disposition);
+                const link = document.createElement('a');
+                link.href = blobURL;
+                link.download = name ? name[1] : 'document';
+                link.click();
+            } else if (/html|svg|xml/.test(blob.type)) {
+                // Uploaded pages are rendered sandboxed so their scripts
+                // never run on our origin.
+                const frame = win.document.createElement('iframe');
+                frame.sandbox = '';
+                frame.src = blobURL;
+                frame.style.cssText = 'border: 0; position: fixed; inset: 0; width: 100%; height: 100%';
+                win.document.body.append(frame);
+            } else {
+                win.location = fragment ? `${blobURL}#${fragment}` : blobURL;
+            }
+        } catch (error) {
+            if (win) win.close();
+            showMessage(`Failed to open document: ${error.message}`, true);
+        }
+    };
+
+    resultsDiv.addEventListener('click', (e) => {
+        const link = e.target.closest('a[data-url]');
+        if (!link) return;
+        e.preventDefault();
+        openDocument(link.dataset.url, link.dataset.download === 'true');
+    });
+
     const updateDocCount = async () => {
         try {
-            const response = await fetch('/api/status');
+            const response = await apiFetch('/api/status');
+            if (!response.ok) throw new Error(`status ${response.status}`);
             const data = await response.json();
             docCountDiv.textContent = data.documents || '0';
         } catch (error) {
@@ -30,6 +135,79 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     };
 
+    const jobsDiv = document.getElementById('jobs');
+
+    const jobStatusText = (job) => {
+        switch (job.status) {
+            case 'indexing':
+                return job.document_ids && job.document_ids.length > 1
+                    ? `Indexing (${job.indexed + job.failed}/${job.document_ids.length})`
+                    : 'Indexing';
+            case 'indexed':
+                return 'Searchable';
+            case 'failed':
+                return `Failed: ${job.error || (job.errors && job.errors[0].error) || 'unknown error'}`;
+            default:
+                return job.status.charAt(0).toUpperCase() + job.status.slice(1);
+        }
+    };
+
+    // Shows one row per uploaded file and follows its indexing job
+    // until the documents are searchable.
+    const addJobRow = (fileName) => {
+        const row = document.createElement('div');
+        row.className = 'job';
+        const name = document.createElement('span');
+        name.textContent = fileName;
+        const status = document.createElement('span');
+        status.className = 'job-status';
+        status.textContent = 'Uploading';
+        row.append(name, status);
+        jobsDiv.prepend(row);
+
+        const show = (job) => {
+            row.className = `job ${job.status}`;
+            status.textContent = jobStatusText(job);
+        };
+        return {
+            show,
+            // Reads the job's Server-Sent Events through fetch, since
+            // EventSource can't send the API key.
+            follow: async (eventsURL) => {
+                try {
+                    const response = await apiFetch(eventsURL);
+                    if (!response.ok || !response.body) return;
+                    const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+                    let buffer = '';
+                    for (;;) {
+                        const { value, done } = await reader.read();
+                        if (done) return;
+                        buffer += value;
+                        let end;
+                        while ((end = buffer.indexOf('\n\n')) >= 0) {
+                            const lines = buffer.slice(0, end).split('\n');
+                            buffer = buffer.slice(end + 2);
+                            const event = lines.find(line => line.startsWith('event:'));
+                            const data = lines.filter(line => line.startsWith('data:'))
+                                .map(line => line.slice(5).trim()).join('\n');
+                            if (!event || event.slice(6).trim() !== 'job' || !data) continue;
+
+                            const job = JSON.parse(data);
+                            show(job);
+                            if (job.status === 'indexed' || job.status === 'failed') {
+                                reader.cancel();
+                                if (job.status === 'indexed') updateDocCount();
+                                return;
+                            }
+                        }
+                    }
+                } catch (error) {
+                    console.error('Failed to follow indexing job:', error);
+                }
+            },
+        };
+    };
+
     const handleFileUpload = async (files) => {
         console.log('Starting file upload for', files.length, 'files');
         showLoading();
@@ -45,22 +223,40 @@ document.addEventListener('DOMContentLoaded', () => {
 
             const formData = new FormData();
             formData.append('file', file);
+            const jobRow = addJobRow(file.name);
 
             try {
                 console.log('Sending upload request for:', file.name);
-                const response = await fetch('/api/upload', {
+                let response = await apiFetch('/api/upload', {
                     method: 'POST',
                     body: formData
                 });
+                // The server turns uploads away while its indexing queue
+                // is full; wait as long as it asks and try again.
+                for (let attempt = 0; response.status === 503 && attempt < 5; attempt++) {
+                    const retryAfter = parseInt(response.headers.get('Retry-After'), 10) || 5;
+                    jobRow.show({ status: 'queued' });
+                    await new Promise(resolve => setTimeout(resolve, retryAfter * 1000));
+                    response = await apiFetch('/api/upload', {
+                        method: 'POST',
+                        body: formData
+                    });
+                }
 
                 console.log('Upload response status:', response.status);
                 const responseText = await response.text();
                 console.log('Upload response:', responseText);
 
                 if (!response.ok) {
+                    jobRow.show({ status: 'failed', error: responseText });
                     throw new Error(`Upload failed: ${responseText}`);
                 }
 
+                const result = JSON.parse(responseText);
+                if (result.events_url) {
+                    jobRow.follow(result.events_url);
+                }
+
                 successCount++;
                 showMessage(`Successfully uploaded ${file.name}`);
             } catch (error) {
@@ -100,7 +296,7 @@ document.addEventListener('DOMContentLoaded', () => {
 
         showLoading();
         try {
-            const response = await fetch(`/api/search?q=${encodeURIComponent(query)}`);
+            const response = await apiFetch(`/api/search?q=${encodeURIComponent(query)}`);
             if (!response.ok) throw new Error('Search failed');
             
             const data = await response.json();
@@ -112,22 +308,30 @@ document.addEventListener('DOMContentLoaded', () => {
 
             resultsDiv.innerHTML = data.results.map(result => `
                 <div class="result-item">
-                    <h3>${result.path || 'Untitled Document'}</h3>
+                    <h3>${escapeHtml(result.title || result.path || 'Untitled Document')}</h3>
                     <div class="meta">
-                        Type: ${result.type || 'Unknown'} | 
-                        Indexed: ${new Date(result.indexed).toLocaleString()}
+                        Type: ${escapeHtml(result.type || 'Unknown')} | 
+                        ${result.author ? `Author: ${escapeHtml(result.author)} | ` : ''}
+                        ${result.page_count ? `Pages: ${escapeHtml(result.page_count)} | ` : ''}
+                        Indexed: ${escapeHtml(new Date(result.indexed).toLocaleString())}
                     </div>
-                    ${result.snippets.map(snippet => `
-                        <div class="snippet">${snippet}</div>
+                    ${result.passages && result.passages.some(p => p.view_url)
+                        ? result.passages.map(passage => `
+                        <div class="snippet">${passage.view_url
+                            ? documentLink(passage.view_url, passage.location)
+                            : escapeHtml(passage.location)}: ${escapeSnippet(passage.snippet)}</div>
+                    `).join('')
+                        : (result.snippets || []).map(snippet => `
+                        <div class="snippet">${escapeSnippet(snippet)}</div>
                     `).join('')}
                     <div class="actions">
-                        <a href="${result.download_url}" target="_blank">Download</a>
-                        <a href="${result.view_url}" target="_blank">View</a>
+                        ${documentLink(result.download_url, 'Download', true)}
+                        ${documentLink(result.view_url, 'View')}
                     </div>
                 </div>
             `).join('');
         } catch (error) {
-            resultsDiv.innerHTML = `<div class="error">Search failed: ${error.message}</div>`;
+            resultsDiv.innerHTML = `<div class="error">Search failed: ${escapeHtml(error.message)}</div>`;
         } finally {
             hideLoading();
         }
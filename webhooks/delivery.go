@@ -0,0 +1,185 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	deliveryWorkers   = 4
+	deliveryQueueSize = 1000
+	deliveryTimeout   = 10 * time.Second
+
+	// A failed delivery is retried maxDeliveryAttempts times in all,
+	// with a jittered delay doubling from retryBaseDelay.
+	maxDeliveryAttempts = 6
+	retryBaseDelay      = 10 * time.Second
+
+	// maxLogEntries is how many delivery attempts are kept per
+	// subscription.
+	maxLogEntries = 100
+)
+
+// Delivery is one attempt at sending an event to a subscription.
+type Delivery struct {
+	EventID    string     `json:"event_id"`
+	EventType  string     `json:"event_type"`
+	Attempt    int        `json:"attempt"`
+	StatusCode int        `json:"status_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Delivered  bool       `json:"delivered"`
+	Duration   float64    `json:"duration_ms"`
+	Time       time.Time  `json:"time"`
+	NextRetry  *time.Time `json:"next_retry,omitempty"`
+}
+
+type delivery struct {
+	sub     *Subscription
+	event   Event
+	attempt int
+}
+
+// Deliveries returns the latest delivery attempts of a subscription,
+// most recent first.
+func (m *Manager) Deliveries(id string) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subscriptions[id]; !ok {
+		return nil, ErrNotFound
+	}
+	entries := m.deliveries[id]
+	result := make([]Delivery, len(entries))
+	for i, d := range entries {
+		result[len(entries)-1-i] = d
+	}
+	return result, nil
+}
+
+// enqueue hands a delivery to the workers. Events are not worth holding
+// up indexing for, so when the queue is full the delivery is dropped.
+func (m *Manager) enqueue(d *delivery) {
+	select {
+	case m.queue <- d:
+	default:
+		log.Printf("[Webhooks] Delivery queue full, dropping %s event %s for %s", d.event.Type, d.event.ID, d.sub.URL)
+		m.record(d.sub.ID, Delivery{
+			EventID:   d.event.ID,
+			EventType: d.event.Type,
+			Attempt:   d.attempt + 1,
+			Error:     "delivery queue full",
+			Time:      time.Now(),
+		})
+	}
+}
+
+func (m *Manager) deliverLoop() {
+	for d := range m.queue {
+		m.deliver(d)
+	}
+}
+
+func (m *Manager) deliver(d *delivery) {
+	d.attempt++
+	start := time.Now()
+	status, err := m.send(d.sub, d.event)
+
+	entry := Delivery{
+		EventID:    d.event.ID,
+		EventType:  d.event.Type,
+		Attempt:    d.attempt,
+		StatusCode: status,
+		Delivered:  err == nil,
+		Duration:   float64(time.Since(start).Microseconds()) / 1000,
+		Time:       start,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		if retryable(status) && d.attempt < maxDeliveryAttempts {
+			delay := retryDelay(d.attempt)
+			next := time.Now().Add(delay)
+			entry.NextRetry = &next
+			time.AfterFunc(delay, func() { m.enqueue(d) })
+		}
+		log.Printf("[Webhooks] Delivery of %s event %s to %s failed (attempt %d/%d): %v",
+			d.event.Type, d.event.ID, d.sub.URL, d.attempt, maxDeliveryAttempts, err)
+	}
+	m.record(d.sub.ID, entry)
+}
+
+// send posts an event, signed with the subscription's secret. The
+// signature is the hex HMAC-SHA256 of the timestamp header, a dot and
+// the body, so receivers can reject replays of old requests.
+func (m *Manager) send(sub *Subscription, event Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ShallowSeek-Webhooks/1.0")
+	req.Header.Set("X-ShallowSeek-Event", event.Type)
+	req.Header.Set("X-ShallowSeek-Delivery", event.ID)
+	req.Header.Set("X-ShallowSeek-Timestamp", timestamp)
+	req.Header.Set("X-ShallowSeek-Signature", "sha256="+Sign(sub.Secret, timestamp, body))
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// Sign computes the signature of a webhook request body.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *Manager) record(id string, entry Delivery) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subscriptions[id]; !ok {
+		return
+	}
+	entries := append(m.deliveries[id], entry)
+	if len(entries) > maxLogEntries {
+		entries = entries[len(entries)-maxLogEntries:]
+	}
+	m.deliveries[id] = entries
+}
+
+// retryable reports whether a failed delivery is worth retrying: the
+// receiver could not be reached, timed out, asked to slow down or failed
+// on its side. Other client errors won't go away by themselves.
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
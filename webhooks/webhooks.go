@@ -0,0 +1,298 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shallowseek/models"
+)
+
+// Event types sent to subscribers.
+const (
+	DocumentIndexed  = "document.indexed"
+	DocumentUpdated  = "document.updated"
+	DocumentDeleted  = "document.deleted"
+	DocumentFailed   = "document.failed"
+	ExtractionFailed = "extraction.failed"
+	Ping             = "ping"
+)
+
+var eventTypes = map[string]bool{
+	DocumentIndexed:  true,
+	DocumentUpdated:  true,
+	DocumentDeleted:  true,
+	DocumentFailed:   true,
+	ExtractionFailed: true,
+}
+
+var (
+	ErrNotFound = errors.New("webhook not found")
+	ErrInvalid  = errors.New("invalid webhook")
+)
+
+// Event is the JSON body of a webhook request.
+type Event struct {
+	ID      string                 `json:"id"`
+	Type    string                 `json:"type"`
+	Created time.Time              `json:"created"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// Subscription sends the events it lists, or every event if it lists
+// none, to a URL. Requests are signed with Secret, which is only shown
+// when the subscription is created.
+type Subscription struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events,omitempty"`
+	Secret  string    `json:"secret,omitempty"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"created"`
+}
+
+func (s *Subscription) wants(eventType string) bool {
+	if !s.Active {
+		return false
+	}
+	if len(s.Events) == 0 || eventType == Ping {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// public returns the subscription without its secret.
+func (s *Subscription) public() Subscription {
+	sub := *s
+	sub.Secret = ""
+	return sub
+}
+
+// Manager keeps the subscriptions in a JSON file and delivers events to
+// them in the background. Pending deliveries and the delivery log are
+// kept in memory only.
+type Manager struct {
+	mu            sync.Mutex
+	path          string
+	subscriptions map[string]*Subscription
+	deliveries    map[string][]Delivery
+	queue         chan *delivery
+	client        *http.Client
+}
+
+func NewManager(path string) *Manager {
+	m := &Manager{
+		path:          path,
+		subscriptions: make(map[string]*Subscription),
+		deliveries:    make(map[string][]Delivery),
+		queue:         make(chan *delivery, deliveryQueueSize),
+		client:        &http.Client{Timeout: deliveryTimeout},
+	}
+
+	if data, err := os.ReadFile(path); err == nil {
+		var subs []*Subscription
+		if err := json.Unmarshal(data, &subs); err != nil {
+			log.Printf("[Webhooks] Error parsing %s: %v", path, err)
+		}
+		for _, sub := range subs {
+			m.subscriptions[sub.ID] = sub
+		}
+		log.Printf("[Webhooks] Loaded %d subscriptions", len(subs))
+	} else if !os.IsNotExist(err) {
+		log.Printf("[Webhooks] Error reading %s: %v", path, err)
+	}
+
+	for i := 0; i < deliveryWorkers; i++ {
+		go m.deliverLoop()
+	}
+	return m
+}
+
+// Create adds a subscription. A secret is generated if none is given.
+func (m *Manager) Create(sub Subscription) (Subscription, error) {
+	if err := validate(&sub); err != nil {
+		return Subscription{}, err
+	}
+	if sub.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Subscription{}, err
+		}
+		sub.Secret = hex.EncodeToString(secret)
+	}
+	sub.ID = models.GenerateID()
+	sub.Created = time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscriptions[sub.ID] = &sub
+	if err := m.save(); err != nil {
+		delete(m.subscriptions, sub.ID)
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// Update replaces the URL, events and active flag of a subscription.
+// The secret is replaced only if a new one is given.
+func (m *Manager) Update(id string, update Subscription) (Subscription, error) {
+	if err := validate(&update); err != nil {
+		return Subscription{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub, ok := m.subscriptions[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	previous := *sub
+	sub.URL = update.URL
+	sub.Events = update.Events
+	sub.Active = update.Active
+	if update.Secret != "" {
+		sub.Secret = update.Secret
+	}
+	if err := m.save(); err != nil {
+		*sub = previous
+		return Subscription{}, err
+	}
+	return sub.public(), nil
+}
+
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub, ok := m.subscriptions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.subscriptions, id)
+	if err := m.save(); err != nil {
+		m.subscriptions[id] = sub
+		return err
+	}
+	delete(m.deliveries, id)
+	return nil
+}
+
+func (m *Manager) Get(id string) (Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub, ok := m.subscriptions[id]
+	if !ok {
+		return Subscription{}, ErrNotFound
+	}
+	return sub.public(), nil
+}
+
+// List returns the subscriptions, oldest first.
+func (m *Manager) List() []Subscription {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subs := make([]Subscription, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		subs = append(subs, sub.public())
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Created.Before(subs[j].Created) })
+	return subs
+}
+
+// Emit sends an event to every subscription that wants it.
+func (m *Manager) Emit(eventType string, data map[string]interface{}) {
+	event := Event{
+		ID:      models.GenerateID(),
+		Type:    eventType,
+		Created: time.Now().UTC(),
+		Data:    data,
+	}
+
+	m.mu.Lock()
+	var targets []*Subscription
+	for _, sub := range m.subscriptions {
+		if sub.wants(eventType) {
+			copied := *sub
+			targets = append(targets, &copied)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, sub := range targets {
+		m.enqueue(&delivery{sub: sub, event: event})
+	}
+}
+
+// Ping sends a ping event to one subscription, active or not.
+func (m *Manager) Ping(id string) error {
+	m.mu.Lock()
+	sub, ok := m.subscriptions[id]
+	var copied Subscription
+	if ok {
+		copied = *sub
+	}
+	m.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+
+	m.enqueue(&delivery{sub: &copied, event: Event{
+		ID:      models.GenerateID(),
+		Type:    Ping,
+		Created: time.Now().UTC(),
+		Data:    map[string]interface{}{"subscription_id": id},
+	}})
+	return nil
+}
+
+// save writes the subscriptions through a temporary file. Called with
+// m.mu held.
+func (m *Manager) save() error {
+	subs := make([]*Subscription, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		subs = append(subs, sub)
+	}
+	data, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to save webhooks: %v", err)
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save webhooks: %v", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to save webhooks: %v", err)
+	}
+	return nil
+}
+
+func validate(sub *Subscription) error {
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: URL must be an absolute http(s) URL, got %q", ErrInvalid, sub.URL)
+	}
+	for _, e := range sub.Events {
+		if !eventTypes[e] {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalid, e)
+		}
+	}
+	return nil
+}